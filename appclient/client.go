@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net"
 	"os"
@@ -107,20 +109,41 @@ func GetSession() string {
 }
 
 func main() {
-	server := "localhost:10399"
-	tcpAddr, err := net.ResolveTCPAddr("tcp4", server)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Fatal error: %s", err.Error())
-		os.Exit(1)
+	server := flag.String("server", "localhost:10399", "address of goproxy4blockchain")
+	useTLS := flag.Bool("tls", false, "connect over TLS")
+	caFile := flag.String("ca", "", "CA certificate to verify the proxy, the system roots by default")
+	certFile := flag.String("cert", "", "client certificate for mTLS")
+	keyFile := flag.String("key", "", "key of the client certificate")
+	serverName := flag.String("servername", "", "name in the proxy certificate, the host of -server by default")
+	clientID := flag.String("client-id", "", "client id to authenticate with the proxy")
+	apiKey := flag.String("apikey", "", "api key of the client")
+	secret := flag.String("secret", "", "shared secret of the client, used when there is no api key")
+	flag.Parse()
+
+	var tlsConfig *tls.Config
+	if *useTLS {
+		var err error
+		tlsConfig, err = utils.ClientTLSConfig(utils.TLSFiles{CertFile: *certFile, KeyFile: *keyFile, CAFile: *caFile, ServerName: *serverName})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Fatal error: %s", err.Error())
+			os.Exit(1)
+		}
 	}
 
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+	conn, err := utils.Dial(*server, tlsConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Fatal error: %s", err.Error())
 		os.Exit(1)
 	}
 
 	fmt.Println("connect success")
+	if *clientID != "" {
+		if err = utils.Authenticate(conn, *clientID, *apiKey, *secret); err != nil {
+			fmt.Fprintf(os.Stderr, "Fatal error: %s", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("authenticated as", *clientID)
+	}
 	send(conn)
 
 }
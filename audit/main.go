@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"goproxy4blockchain/handler"
+	"os"
+	"time"
+)
+
+/* audit checks and exports the audit log written by goproxy4blockchain, see the audit section of config.yaml.
+   usage:
+     audit verify -file ./log/audit.log -head 1234:9f86d0...
+     audit export -file ./log/audit.log -from 2026-01-01T00:00:00Z -channel vvtrip -out audit-2026.jsonl
+
+   verify reports every gap and modified entry and exits with 1 when the chain is broken; with -head, the seq:hash the
+   proxy logged or served on GET /audit, it also finds the entries cut off the end of the file, which the chain can't;
+   export writes the intact entries as json lines and fails as verify does.
+   审计日志工具：verify校验哈希链是否完整，export按条件导出JSONL。
+*/
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "verify":
+		verify(os.Args[2:])
+	case "export":
+		export(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: audit verify|export -file audit.log [flags], audit <command> -h for the flags")
+	os.Exit(2)
+}
+
+func verify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	file := flags.String("file", "./log/audit.log", "audit log to verify")
+	head := flags.String("head", "", "seq:hash of the last entry the proxy appended, to find a truncated file")
+	flags.Parse(args)
+
+	check := verifyFile(*file, nil)
+	if *head != "" {
+		expected, err := handler.ParseAuditHead(*head)
+		if err != nil {
+			fail(err)
+		}
+		check.ExpectHead(expected)
+	}
+	report(*file, check)
+	fmt.Printf("%s: %d entries, chain intact\n", *file, check.Entries)
+}
+
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	file := flags.String("file", "./log/audit.log", "audit log to export")
+	out := flags.String("out", "", "jsonl file to write, stdout by default")
+	from := flags.String("from", "", "first time to export, RFC 3339")
+	to := flags.String("to", "", "time to export until, RFC 3339, excluded")
+	principal := flags.String("principal", "", "only the entries of this principal")
+	channel := flags.String("channel", "", "only the entries of this channel")
+	method := flags.String("method", "", "only the entries of this method")
+	flags.Parse(args)
+
+	var since, until time.Time
+	var err error
+	if *from != "" {
+		if since, err = time.Parse(time.RFC3339, *from); err != nil {
+			fail(err)
+		}
+	}
+	if *to != "" {
+		if until, err = time.Parse(time.RFC3339, *to); err != nil {
+			fail(err)
+		}
+	}
+	writer := os.Stdout
+	if *out != "" {
+		if writer, err = os.Create(*out); err != nil {
+			fail(err)
+		}
+		defer writer.Close()
+	}
+	encoder := json.NewEncoder(writer)
+	exported := 0
+	check := verifyFile(*file, func(entry *handler.AuditEntry) error {
+		at, err := time.Parse(time.RFC3339Nano, entry.Time)
+		if err != nil {
+			return fmt.Errorf("entry %d: %v", entry.Seq, err)
+		}
+		if (!since.IsZero() && at.Before(since)) || (!until.IsZero() && !at.Before(until)) ||
+			(*principal != "" && entry.Principal != *principal) ||
+			(*channel != "" && entry.Channel != *channel) ||
+			(*method != "" && entry.Method != *method) {
+			return nil
+		}
+		exported++
+		return encoder.Encode(entry)
+	})
+	report(*file, check)
+	fmt.Fprintf(os.Stderr, "%d of %d entries exported\n", exported, check.Entries)
+}
+
+func verifyFile(path string, fn func(entry *handler.AuditEntry) error) *handler.AuditCheck {
+	file, err := os.Open(path)
+	if err != nil {
+		fail(err)
+	}
+	defer file.Close()
+	check, err := handler.VerifyAudit(file, fn)
+	if err != nil {
+		fail(err)
+	}
+	return check
+}
+
+//report is to print the problems found and exit when the chain is broken.
+func report(path string, check *handler.AuditCheck) {
+	if check.Valid() {
+		return
+	}
+	for _, problem := range check.Problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	fail(fmt.Errorf("%s: %d problems found, the audit log was modified", path, len(check.Problems)))
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "Fatal error: %s\n", err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"fmt"
+	"goproxy4blockchain/utils"
+	"strings"
+	"sync/atomic"
+)
+
+/* Who may call which method on which channel and keys, in the acl section of config.yaml:
+   acl:
+     rules:
+       - principals: [app-1]          # client ids, "*" for any authenticated client, "anonymous" without authentication
+         groups: [farm]               # or the groups of the clients
+         channels: [vvtrip]           # "*" or omitted for any channel
+         keyprefixes: ["00000000"]    # omitted for any key
+         methods: [source-state, source-transactions]
+       - groups: [lab]
+         effect: deny                 # allow by default
+         methods: [source-save]
+   The first matching rule decides, a request matching no rule is denied.
+   With auth the acl is required, the config is rejected without it; without auth and acl every request is allowed.
+   访问控制：按客户端或分组限制可访问的channel、key前缀和方法，默认拒绝。
+*/
+
+//AnonymousPrincipal is the principal name matching the requests of unauthenticated connections.
+const AnonymousPrincipal = "anonymous"
+
+//ACLRule is one rule of the acl, an empty list matches anything.
+type ACLRule struct {
+	Principals  []string
+	Groups      []string
+	Channels    []string
+	KeyPrefixes []string
+	Methods     []string
+	Allow       bool
+}
+
+//ACL is the authorisation policy, deny-by-default.
+type ACL struct {
+	Rules []ACLRule
+}
+
+//ACLDecision is the result of checking a request, logged for audit.
+type ACLDecision struct {
+	Allow     bool
+	Rule      int // index of the matching rule, -1 if none matched
+	Principal string
+	Groups    []string
+	Channel   string
+	Key       string
+	Method    string
+}
+
+//String is the audit line of the decision.
+func (d ACLDecision) String() string {
+	result := "deny"
+	if d.Allow {
+		result = "allow"
+	}
+	rule := "default"
+	if d.Rule >= 0 {
+		rule = fmt.Sprint(d.Rule)
+	}
+	return fmt.Sprintf("acl %s principal=%s groups=%s channel=%s key=%s method=%s rule=%s",
+		result, d.Principal, strings.Join(d.Groups, ","), d.Channel, d.Key, d.Method, rule)
+}
+
+//NewACL is to read the acl section of the config, nil section means no authorisation.
+func NewACL(section map[interface{}]interface{}) (*ACL, error) {
+	if section == nil {
+		return nil, nil
+	}
+	acl := &ACL{}
+	rules, _ := section["rules"].([]interface{})
+	for i, value := range rules {
+		entry, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("acl rule %d: expect a map", i)
+		}
+		rule := ACLRule{
+			Principals:  stringList(entry["principals"]),
+			Groups:      stringList(entry["groups"]),
+			Channels:    stringList(entry["channels"]),
+			KeyPrefixes: stringList(entry["keyprefixes"]),
+			Methods:     stringList(entry["methods"]),
+			Allow:       true,
+		}
+		switch effect := fmt.Sprint(entry["effect"]); effect {
+		case "<nil>", "allow":
+		case "deny":
+			rule.Allow = false
+		default:
+			return nil, fmt.Errorf("acl rule %d: unknown effect %q, use allow or deny", i, effect)
+		}
+		acl.Rules = append(acl.Rules, rule)
+	}
+	return acl, nil
+}
+
+//stringList is a yaml list, or a single value, as strings.
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+//Check is to decide whether principal may call method on the key of channel; principal is nil without authentication.
+func (acl *ACL) Check(principal *Principal, channel string, key string, method string) ACLDecision {
+	decision := ACLDecision{Rule: -1, Principal: AnonymousPrincipal, Channel: channel, Key: key, Method: method}
+	if principal != nil {
+		decision.Principal = principal.ID
+		decision.Groups = principal.Groups
+	}
+	for i, rule := range acl.Rules {
+		if rule.matches(principal, channel, key, method) {
+			decision.Allow = rule.Allow
+			decision.Rule = i
+			return decision
+		}
+	}
+	return decision
+}
+
+func (rule ACLRule) matches(principal *Principal, channel string, key string, method string) bool {
+	if len(rule.Principals) > 0 || len(rule.Groups) > 0 {
+		if !rule.matchesPrincipal(principal) {
+			return false
+		}
+	}
+	if len(rule.Channels) > 0 && !contains(rule.Channels, channel) {
+		return false
+	}
+	if len(rule.Methods) > 0 && !contains(rule.Methods, method) {
+		return false
+	}
+	if len(rule.KeyPrefixes) > 0 {
+		for _, prefix := range rule.KeyPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (rule ACLRule) matchesPrincipal(principal *Principal) bool {
+	if principal == nil {
+		for _, p := range rule.Principals {
+			if p == AnonymousPrincipal {
+				return true
+			}
+		}
+		return false
+	}
+	for _, p := range rule.Principals {
+		if p == "*" || p == principal.ID {
+			return true
+		}
+	}
+	for _, g := range principal.Groups {
+		if contains(rule.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+//contains tells whether list has value, "*" matches any value.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == "*" || item == value {
+			return true
+		}
+	}
+	return false
+}
+
+var currentACL atomic.Value
+
+func init() {
+	currentACL.Store((*ACL)(nil))
+}
+
+//UseACL is to authorise the requests with acl from now on, nil allows everything; the config reload swaps it.
+func UseACL(acl *ACL) {
+	currentACL.Store(acl)
+}
+
+//CurrentACL is the acl in use, nil when there is none.
+func CurrentACL() *ACL {
+	return currentACL.Load().(*ACL)
+}
+
+//ACLMiddleware is the Middleware of the acl in use, so a new acl applies to the next request.
+func ACLMiddleware(next Controller) Controller {
+	return ControllerFunc(func(message Msg) []byte {
+		acl := CurrentACL()
+		if acl == nil {
+			return next.Excute(message)
+		}
+		return acl.Middleware(next).Excute(message)
+	})
+}
+
+//Middleware is to deny the forbidden requests before they are sent to the block chain service.
+func (acl *ACL) Middleware(next Controller) Controller {
+	return ControllerFunc(func(message Msg) []byte {
+		rpcRequest := message.Content
+		params := requestParams(rpcRequest.Params)
+		decision := acl.Check(message.Principal(), params.Channel, params.Key, rpcRequest.Method)
+		log := utils.LoggerFrom(message.Context())
+		if !decision.Allow {
+			log.Warn(decision)
+			return errorResponse(rpcRequest.ID, ErrCodeForbidden, "forbidden", nil)
+		}
+		log.Info(decision)
+		return next.Excute(message)
+	})
+}
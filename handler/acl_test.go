@@ -0,0 +1,77 @@
+package handler
+
+import "testing"
+
+func TestACLCheck(t *testing.T) {
+	acl, err := NewACL(section(t, `
+rules:
+  - principals: [intruder]
+    effect: deny
+  - principals: [app-1]
+    channels: [vvtrip]
+    methods: [source-state, source-transactions]
+  - groups: [farm]
+    channels: [vvtrip]
+    keyprefixes: ["0000", "0001"]
+    methods: [source-save]
+  - groups: [lab]
+    effect: deny
+    methods: [source-save]
+  - groups: [lab]
+  - principals: "*"
+    channels: [public]
+    methods: [source-state]
+  - principals: [anonymous]
+    channels: [open]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app1 := &Principal{ID: "app-1", Groups: []string{"farm"}}
+	lab := &Principal{ID: "lab-1", Groups: []string{"lab"}}
+	other := &Principal{ID: "app-9"}
+	tests := []struct {
+		name      string
+		principal *Principal
+		channel   string
+		key       string
+		method    string
+		allow     bool
+		rule      int
+	}{
+		{name: "principal, channel and method", principal: app1, channel: "vvtrip", key: "9999", method: "source-state", allow: true, rule: 1},
+		{name: "another method of the principal", principal: app1, channel: "vvtrip", key: "9999", method: "source-history", rule: -1},
+		{name: "another channel of the principal", principal: app1, channel: "other", key: "9999", method: "source-state", rule: -1},
+		{name: "group with key prefix", principal: app1, channel: "vvtrip", key: "00011234", method: "source-save", allow: true, rule: 2},
+		{name: "group outside the key prefixes", principal: app1, channel: "vvtrip", key: "00021234", method: "source-save", rule: -1},
+		{name: "explicit deny first", principal: &Principal{ID: "intruder", Groups: []string{"farm"}}, channel: "vvtrip", key: "0000", method: "source-save", rule: 0},
+		{name: "deny of a group before its allow", principal: lab, channel: "vvtrip", key: "0000", method: "source-save", rule: 3},
+		{name: "first match wins", principal: lab, channel: "vvtrip", key: "0000", method: "source-state", allow: true, rule: 4},
+		{name: "star principal", principal: other, channel: "public", key: "1", method: "source-state", allow: true, rule: 5},
+		{name: "star principal, other channel", principal: other, channel: "vvtrip", key: "1", method: "source-state", rule: -1},
+		{name: "star isn't anonymous", principal: nil, channel: "public", key: "1", method: "source-state", rule: -1},
+		{name: "anonymous", principal: nil, channel: "open", key: "1", method: "source-save", allow: true, rule: 6},
+		{name: "deny by default", principal: other, channel: "open", key: "1", method: "source-state", rule: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := acl.Check(tt.principal, tt.channel, tt.key, tt.method)
+			if decision.Allow != tt.allow || decision.Rule != tt.rule {
+				t.Errorf("%s, want allow %v by rule %d", decision, tt.allow, tt.rule)
+			}
+		})
+	}
+}
+
+func TestNewACLEffect(t *testing.T) {
+	if _, err := NewACL(section(t, "rules: [{principals: [app-1], effect: maybe}]")); err == nil {
+		t.Error("effect maybe is read")
+	}
+	acl, err := NewACL(section(t, "rules: []"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision := acl.Check(&Principal{ID: "app-1"}, "vvtrip", "1", "source-state"); decision.Allow {
+		t.Errorf("%s by an acl without rules", decision)
+	}
+}
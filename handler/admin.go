@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"encoding/json"
+	"goproxy4blockchain/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//the operators query the running proxy over http, on adminhost, a loopback address the customers can't reach:
+// GET /healthz                         the process is alive
+// GET /readyz                          the config is loaded and the block chain service is reachable, 503 otherwise
+// GET /limits                          current usage of the rate limits and in-flight quotas
+// GET /connections                     the app client connections: remote address, principal, idle time, requests in flight
+// POST /connections/disconnect?id=3    close a connection
+// GET /routes                          the route table and the middlewares
+// GET /loglevel, PUT /loglevel?level=debug    read or change the log level
+// GET /audit                           seq and hash of the last entry of the audit log, for audit verify -head
+//运维使用的http接口，只应绑定在内网地址。
+
+//upstreamCheckTimeout bounds the check of the block chain service by /readyz.
+const upstreamCheckTimeout = 3 * time.Second
+
+//AdminServer serves the admin interface.
+type AdminServer struct {
+	mux   *http.ServeMux
+	ready int32
+	audit *AuditLog
+}
+
+//NewAdminServer returns the http handler of the admin interface.
+func NewAdminServer() *AdminServer {
+	as := &AdminServer{}
+	as.mux = http.NewServeMux()
+	as.mux.HandleFunc("/healthz", as.serveHealth)
+	as.mux.HandleFunc("/readyz", as.serveReady)
+	as.mux.HandleFunc("/limits", as.serveLimits)
+	as.mux.HandleFunc("/connections", as.serveConnections)
+	as.mux.HandleFunc("/connections/disconnect", as.serveDisconnect)
+	as.mux.HandleFunc("/routes", as.serveRoutes)
+	as.mux.HandleFunc("/loglevel", as.serveLogLevel)
+	as.mux.HandleFunc("/audit", as.serveAudit)
+	return as
+}
+
+//UseAudit is to serve the head of audit on /audit.
+func (as *AdminServer) UseAudit(audit *AuditLog) {
+	as.audit = audit
+}
+
+//SetReady is to tell /readyz the config is loaded and the proxy accepts the app clients.
+func (as *AdminServer) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&as.ready, v)
+}
+
+func (as *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	as.mux.ServeHTTP(w, r)
+}
+
+//writeJSON is to answer an admin request with v as json.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+//writeJSONStatus is writeJSON with another status than 200.
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		utils.Log("xxx writeJSON()", err)
+	}
+}
+
+//allowMethod is to refuse a request with another method than the ones given.
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSONStatus(w, http.StatusMethodNotAllowed, map[string]string{"error": r.Method + " not allowed"})
+	return false
+}
+
+func (as *AdminServer) serveHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+func (as *AdminServer) serveReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"config": "ok", "upstream": "ok"}
+	ready := true
+	if atomic.LoadInt32(&as.ready) == 0 {
+		checks["config"] = "not loaded"
+		ready = false
+	}
+	if err := CheckUpstream(upstreamCheckTimeout); err != nil {
+		checks["upstream"] = err.Error()
+		ready = false
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSONStatus(w, status, map[string]interface{}{"ready": ready, "checks": checks})
+}
+
+func (as *AdminServer) serveLimits(w http.ResponseWriter, r *http.Request) {
+	limiter := CurrentLimiter()
+	if limiter == nil {
+		writeJSON(w, []LimitUsage{})
+		return
+	}
+	writeJSON(w, limiter.Usage())
+}
+
+func (as *AdminServer) serveAudit(w http.ResponseWriter, r *http.Request) {
+	if as.audit == nil {
+		writeJSONStatus(w, http.StatusNotFound, map[string]string{"error": "no audit section in the config"})
+		return
+	}
+	head := as.audit.Head()
+	writeJSON(w, map[string]interface{}{"file": as.audit.Path, "seq": head.Seq, "hash": head.Hash, "head": head.String()})
+}
+
+func (as *AdminServer) serveConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, Connections())
+}
+
+func (as *AdminServer) serveDisconnect(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		writeJSONStatus(w, http.StatusBadRequest, map[string]string{"error": "id of the connection expected"})
+		return
+	}
+	info, ok := Disconnect(id)
+	if !ok {
+		writeJSONStatus(w, http.StatusNotFound, map[string]string{"error": "no connection " + r.FormValue("id")})
+		return
+	}
+	utils.Log("admin disconnected", info.RemoteAddr, info.Principal)
+	writeJSON(w, info)
+}
+
+func (as *AdminServer) serveRoutes(w http.ResponseWriter, r *http.Request) {
+	routes, middlewareNames := RouteTable()
+	writeJSON(w, map[string]interface{}{"routes": routes, "middlewares": middlewareNames})
+}
+
+func (as *AdminServer) serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPut, http.MethodPost) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		level, err := utils.ParseLevel(r.FormValue("level"))
+		if err != nil {
+			writeJSONStatus(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		previous := utils.GetLevel()
+		utils.SetLevel(level)
+		utils.Log("admin changed the log level from", previous, "to", level)
+	}
+	writeJSON(w, map[string]string{"level": utils.GetLevel().String()})
+}
@@ -0,0 +1,395 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goproxy4blockchain/jsonrpc"
+	"goproxy4blockchain/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Audit trail of the chain operations, in the audit section of config.yaml:
+   audit:
+     file: ./log/audit.log        # append only, never rotated
+     sync: true                   # fsync every entry, slower but nothing is lost on a crash
+   Each request is one json line chained to the one before by its hash:
+     {"seq":1,"time":"...","principal":"app-1","method":"source-save","channel":"vvtrip","key":"...",
+      "paramsDigest":"sha256 of the params","tx_id":"...","code":0,"prevHash":"","hash":"..."}
+   code is 0 when the request succeeded, the json-rpc error code otherwise. The audit command verifies and exports the file.
+   The chain can't tell the last entries were cut off the end of the file, the head is kept outside it: the seq and hash
+   of the last entry are logged at startup and shutdown, served on GET /audit of the admin interface and exported as the
+   audit_head_seq metric; audit verify -head seq:hash checks the file still ends there.
+   审计日志：记录每次链上操作，逐条哈希链接，任何删除或篡改都能被audit verify发现。
+*/
+
+//AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Seq          uint64 `json:"seq"`
+	Time         string `json:"time"`
+	Principal    string `json:"principal"`
+	Method       string `json:"method"`
+	Channel      string `json:"channel"`
+	Key          string `json:"key"`
+	ParamsDigest string `json:"paramsDigest"`
+	TxID         string `json:"tx_id,omitempty"`
+	Code         int    `json:"code"`
+	PrevHash     string `json:"prevHash"`
+	Hash         string `json:"hash,omitempty"`
+}
+
+//hashOf is the hash of the entry without its own hash, chained to the one before by PrevHash.
+func (e AuditEntry) hashOf() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+//AuditLog appends the entries to the audit file.
+type AuditLog struct {
+	Path string
+	Sync bool
+
+	mu       sync.Mutex
+	file     *os.File
+	seq      uint64
+	lastHash string
+	closed   bool
+	// pending are the requests being answered, appended when they are
+	pending   map[uint64]AuditEntry
+	pendingID uint64
+}
+
+//NewAuditLog is to read the audit section of the config, nil section means no audit.
+//The chain goes on from the last entry of the file, which must be intact.
+func NewAuditLog(section map[interface{}]interface{}) (*AuditLog, error) {
+	if section == nil {
+		return nil, nil
+	}
+	a := &AuditLog{Path: utils.GetElement("file", section), Sync: fmt.Sprint(section["sync"]) == "true",
+		pending: make(map[uint64]AuditEntry)}
+	if a.Path == "" {
+		return nil, fmt.Errorf("audit needs file")
+	}
+	if err := os.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+		return nil, err
+	}
+	last, err := lastAuditEntry(a.Path)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		a.seq, a.lastHash = last.Seq, last.Hash
+	}
+	if a.file, err = os.OpenFile(a.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640); err != nil {
+		return nil, err
+	}
+	utils.AuditAppended(a.seq)
+	// the hash would be masked as a token in the text of the line
+	utils.With(utils.F("auditHead", a.head().String())).Info("audit log", a.Path, "opened")
+	return a, nil
+}
+
+//AuditHead is the last entry of the audit log, to check later the file wasn't truncated.
+type AuditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+func (h AuditHead) String() string {
+	return fmt.Sprintf("%d:%s", h.Seq, h.Hash)
+}
+
+//Head is the last entry appended.
+func (a *AuditLog) Head() AuditHead {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.head()
+}
+
+func (a *AuditLog) head() AuditHead {
+	return AuditHead{Seq: a.seq, Hash: a.lastHash}
+}
+
+//lastAuditEntry is the last entry of the file, nil when there is none.
+func lastAuditEntry(path string) (*AuditEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var last []byte
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			last = line
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+	entry, err := parseAuditLine(last)
+	if err != nil {
+		return nil, fmt.Errorf("audit file %s: last entry: %v, run audit verify", path, err)
+	}
+	return entry, nil
+}
+
+//parseAuditLine is to read one line and check it hashes to its hash; a line written differently fails too.
+func parseAuditLine(line []byte) (*AuditEntry, error) {
+	var entry AuditEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+	hash, err := entry.hashOf()
+	if err != nil {
+		return nil, err
+	}
+	if hash != entry.Hash {
+		return nil, fmt.Errorf("entry %d is modified, hash mismatch", entry.Seq)
+	}
+	if canonical, _ := json.Marshal(entry); !bytes.Equal(canonical, line) {
+		return nil, fmt.Errorf("entry %d is modified, unexpected content", entry.Seq)
+	}
+	return &entry, nil
+}
+
+//Append is to chain entry to the last one and write it.
+func (a *AuditLog) Append(entry AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.append(entry)
+}
+
+func (a *AuditLog) append(entry AuditEntry) error {
+	if a.closed {
+		return fmt.Errorf("audit log closed, entry lost: %s", entry.describe())
+	}
+	entry.Seq = a.seq + 1
+	entry.PrevHash = a.lastHash
+	hash, err := entry.hashOf()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if a.Sync {
+		if err = a.file.Sync(); err != nil {
+			return err
+		}
+	}
+	a.seq, a.lastHash = entry.Seq, entry.Hash
+	utils.AuditAppended(a.seq)
+	return nil
+}
+
+//begin is to note a request being answered, its entry is appended by end.
+func (a *AuditLog) begin(entry AuditEntry) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pendingID++
+	a.pending[a.pendingID] = entry
+	return a.pendingID
+}
+
+//end is to append the entry of the request begun as id.
+func (a *AuditLog) end(id uint64, entry AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.pending, id)
+	return a.append(entry)
+}
+
+//describe is the entry for the operators, when it couldn't be written.
+func (e AuditEntry) describe() string {
+	return fmt.Sprintf("%s by %s channel=%s key=%s at %s", e.Method, e.Principal, e.Channel, e.Key, e.Time)
+}
+
+//Close is to write the audit file to disk and close it; the entries of the requests still being answered are lost,
+//they are named in the error.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	utils.With(utils.F("auditHead", a.head().String())).Info("audit log", a.Path, "closed")
+	err := a.file.Sync()
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	if len(a.pending) > 0 {
+		lost := make([]string, 0, len(a.pending))
+		for _, entry := range a.pending {
+			lost = append(lost, entry.describe())
+		}
+		sort.Strings(lost)
+		if err != nil {
+			lost = append(lost, err.Error())
+		}
+		return fmt.Errorf("%d requests still answered, their entries are lost:\n  %s", len(a.pending), strings.Join(lost, "\n  "))
+	}
+	return err
+}
+
+//paramsDigest is the sha256 of the params as sent by the app client.
+func paramsDigest(params interface{}) string {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+//responseOutcome is the code and the tx_id of a response, a request without response is an internal error.
+func responseOutcome(response []byte) (code int, txID string) {
+	var rpcResp jsonrpc.RPCResponse
+	if len(response) == 0 || json.Unmarshal(response, &rpcResp) != nil {
+		return ErrCodeInternal, ""
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error.Code, ""
+	}
+	if result, ok := rpcResp.Result.(map[string]interface{}); ok {
+		txID, _ = result["tx_id"].(string)
+	}
+	return 0, txID
+}
+
+//Middleware is to record every request with its outcome, it should be used first so the denied requests are recorded too.
+func (a *AuditLog) Middleware(next Controller) Controller {
+	return ControllerFunc(func(message Msg) []byte {
+		rpcRequest := message.Content
+		params := requestParams(rpcRequest.Params)
+		entry := AuditEntry{
+			Time:         time.Now().UTC().Format(time.RFC3339Nano),
+			Principal:    AnonymousPrincipal,
+			Method:       rpcRequest.Method,
+			Channel:      params.Channel,
+			Key:          params.Key,
+			ParamsDigest: paramsDigest(rpcRequest.Params),
+		}
+		if p := message.Principal(); p != nil {
+			entry.Principal = p.ID
+		}
+		id := a.begin(entry)
+		response := next.Excute(message)
+		entry.Code, entry.TxID = responseOutcome(response)
+		if err := a.end(id, entry); err != nil {
+			utils.LoggerFrom(message.Context()).Error("xxx audit:", err)
+		}
+		return response
+	})
+}
+
+//AuditCheck is the result of verifying an audit file.
+type AuditCheck struct {
+	Entries uint64
+	// Head is the last entry of the file, as it claims to be.
+	Head AuditHead
+	// Problems are the gaps and the modified entries, by line.
+	Problems []string
+}
+
+//Valid tells whether the chain is intact.
+func (c *AuditCheck) Valid() bool {
+	return len(c.Problems) == 0
+}
+
+//ExpectHead is to report the entries missing at the end of the file, head is the last one the proxy appended.
+func (c *AuditCheck) ExpectHead(head AuditHead) {
+	switch {
+	case c.Head.Seq < head.Seq:
+		c.Problems = append(c.Problems, fmt.Sprintf("truncated, the file ends at entry %d, entry %d was appended", c.Head.Seq, head.Seq))
+	case c.Head.Seq == head.Seq && c.Head.Hash != head.Hash:
+		c.Problems = append(c.Problems, fmt.Sprintf("entry %d isn't the one appended, hash %s", head.Seq, c.Head.Hash))
+	}
+}
+
+//ParseAuditHead is to read a head written as seq:hash.
+func ParseAuditHead(s string) (AuditHead, error) {
+	var head AuditHead
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return head, fmt.Errorf("audit head %q, expect seq:hash", s)
+	}
+	seq, err := strconv.ParseUint(s[:i], 10, 64)
+	if err != nil {
+		return head, fmt.Errorf("audit head %q: %v", s, err)
+	}
+	return AuditHead{Seq: seq, Hash: s[i+1:]}, nil
+}
+
+//VerifyAudit is to check every entry of an audit file and the chain between them.
+//fn, if not nil, is called with each intact entry.
+func VerifyAudit(r io.Reader, fn func(entry *AuditEntry) error) (*AuditCheck, error) {
+	check := &AuditCheck{}
+	var seq uint64
+	var prevHash string
+	reader := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return check, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			entry, parseErr := parseAuditLine(line)
+			switch {
+			case parseErr != nil:
+				check.Problems = append(check.Problems, fmt.Sprintf("line %d: %v", n, parseErr))
+				// the chain goes on from what the entry claims, so a single bad line isn't reported twice
+				var claimed AuditEntry
+				if json.Unmarshal(line, &claimed) == nil {
+					seq, prevHash = claimed.Seq, claimed.Hash
+				}
+			case entry.Seq != seq+1:
+				check.Problems = append(check.Problems, fmt.Sprintf("line %d: gap, entry %d follows entry %d", n, entry.Seq, seq))
+				seq, prevHash = entry.Seq, entry.Hash
+			case entry.PrevHash != prevHash:
+				check.Problems = append(check.Problems, fmt.Sprintf("line %d: entry %d isn't chained to entry %d", n, entry.Seq, seq))
+				seq, prevHash = entry.Seq, entry.Hash
+			default:
+				seq, prevHash = entry.Seq, entry.Hash
+				check.Entries++
+				if fn != nil {
+					if err := fn(entry); err != nil {
+						return check, err
+					}
+				}
+			}
+			check.Head = AuditHead{Seq: seq, Hash: prevHash}
+		}
+		if err == io.EOF {
+			return check, nil
+		}
+	}
+}
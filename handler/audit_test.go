@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//writeAudit is an audit file of n entries, as lines, with the head the proxy would report.
+func writeAudit(t *testing.T, n int) ([]string, AuditHead) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := NewAuditLog(map[interface{}]interface{}{"file": path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		entry := AuditEntry{Time: "2026-10-19T10:00:00Z", Principal: "app-1", Method: WriteMethod, Channel: "vvtrip",
+			Key: strings.Repeat("0", i+1), ParamsDigest: "digest"}
+		if err := audit.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head := audit.Head()
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), head
+}
+
+func verifyLines(t *testing.T, lines []string, head AuditHead) *AuditCheck {
+	check, err := VerifyAudit(bytes.NewBufferString(strings.Join(lines, "\n")+"\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check.ExpectHead(head)
+	return check
+}
+
+func TestVerifyAudit(t *testing.T) {
+	lines, head := writeAudit(t, 5)
+	if head.Seq != 5 {
+		t.Fatalf("head %v, want seq 5", head)
+	}
+	check := verifyLines(t, lines, head)
+	if !check.Valid() || check.Entries != 5 || check.Head != head {
+		t.Fatalf("intact file: %d entries, head %v, problems %q", check.Entries, check.Head, check.Problems)
+	}
+
+	tests := []struct {
+		name   string
+		change func(lines []string) []string
+		want   string
+	}{
+		{name: "edited", change: func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], `"key":"000"`, `"key":"001"`, 1)
+			return lines
+		}, want: "line 3: entry 3 is modified"},
+		{name: "reformatted", change: func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"seq":2,`, `"seq": 2,`, 1)
+			return lines
+		}, want: "line 2: entry 2 is modified"},
+		{name: "deleted", change: func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, want: "line 2: gap, entry 3 follows entry 1"},
+		{name: "reordered", change: func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, want: "line 2: gap, entry 3 follows entry 1"},
+		{name: "truncated", change: func(lines []string) []string {
+			return lines[:3]
+		}, want: "truncated, the file ends at entry 3, entry 5 was appended"},
+		{name: "last entry rehashed", change: func(lines []string) []string {
+			entry, err := parseAuditLine([]byte(lines[4]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry.Key = "forged"
+			entry.Hash, _ = entry.hashOf()
+			line, _ := json.Marshal(entry)
+			lines[4] = string(line)
+			return lines
+		}, want: "entry 5 isn't the one appended"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := tt.change(append([]string(nil), lines...))
+			check := verifyLines(t, changed, head)
+			if check.Valid() {
+				t.Fatalf("%s file verified, %d entries", tt.name, check.Entries)
+			}
+			if !strings.HasPrefix(check.Problems[0], tt.want) {
+				t.Errorf("problems %q, want %q first", check.Problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAuditHead(t *testing.T) {
+	head, err := ParseAuditHead("42:9f86d0")
+	if err != nil || head != (AuditHead{Seq: 42, Hash: "9f86d0"}) {
+		t.Errorf("head %v, err %v", head, err)
+	}
+	for _, s := range []string{"", "42", "x:9f86d0", "-1:9f86d0"} {
+		if _, err := ParseAuditHead(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goproxy4blockchain/jsonrpc"
+	"goproxy4blockchain/utils"
+	"net"
+	"strconv"
+	"time"
+)
+
+/* The clients and how they authenticate are in the auth section of config.yaml:
+   auth:
+     required: true      # unauthenticated connections are closed after timeout
+     timeout: 5          # seconds
+     mtls: true          # a verified client certificate whose CN is a client id authenticates the connection
+     clients:
+       app-1:
+         apikey: 0b8f...
+         groups: [farm, lab]
+       app-2:
+         secret: 5c1e... # HMAC challenge-response
+   The frames are described in utils/auth.go.
+*/
+
+//ErrCodeUnauthorized is returned when the authentication fails or is missing.
+const ErrCodeUnauthorized = -32000
+
+//Principal is the authenticated client of a connection.
+type Principal struct {
+	ID     string   `json:"id"`
+	Groups []string `json:"groups,omitempty"`
+	// Method is how the client authenticated: apikey, hmac or mtls.
+	Method string `json:"method"`
+}
+
+type principalKey struct{}
+
+//NewPrincipalContext returns a copy of ctx carrying principal.
+func NewPrincipalContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+//PrincipalFromContext is the principal of a request, nil if the connection isn't authenticated.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+//Principal is the authenticated client who sent the message, nil if none.
+func (m Msg) Principal() *Principal {
+	return PrincipalFromContext(m.Context())
+}
+
+type clientCredential struct {
+	apiKey string
+	secret string
+	groups []string
+}
+
+//Authenticator holds the credentials of the clients.
+type Authenticator struct {
+	Required bool
+	Timeout  time.Duration
+	MTLS     bool
+	clients  map[string]clientCredential
+}
+
+//NewAuthenticator is to read the auth section of the config, nil section means no authentication.
+func NewAuthenticator(section map[interface{}]interface{}) (*Authenticator, error) {
+	auth := &Authenticator{Timeout: 5 * time.Second, clients: make(map[string]clientCredential)}
+	if section == nil {
+		return auth, nil
+	}
+	auth.Required = fmt.Sprint(section["required"]) == "true"
+	auth.MTLS = fmt.Sprint(section["mtls"]) == "true"
+	if _, ok := section["timeout"]; ok {
+		seconds, err := strconv.Atoi(utils.GetElement("timeout", section))
+		if err != nil {
+			return nil, fmt.Errorf("auth timeout: %v", err)
+		}
+		auth.Timeout = time.Duration(seconds) * time.Second
+	}
+	clients := utils.GetSection("clients", section)
+	for id, value := range clients {
+		entry, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("auth client %v: expect apikey or secret", id)
+		}
+		cred := clientCredential{}
+		if v, ok := entry["apikey"]; ok {
+			cred.apiKey = fmt.Sprint(v)
+		}
+		if v, ok := entry["secret"]; ok {
+			cred.secret = fmt.Sprint(v)
+		}
+		if groups, ok := entry["groups"].([]interface{}); ok {
+			for _, g := range groups {
+				cred.groups = append(cred.groups, fmt.Sprint(g))
+			}
+		}
+		if cred.apiKey == "" && cred.secret == "" {
+			return nil, fmt.Errorf("auth client %v: expect apikey or secret", id)
+		}
+		auth.clients[fmt.Sprint(id)] = cred
+	}
+	return auth, nil
+}
+
+//WithOptions is a copy of auth for the connections of a listener, with the same clients.
+func (auth *Authenticator) WithOptions(required bool, timeout time.Duration, mtls bool) *Authenticator {
+	return &Authenticator{Required: required, Timeout: timeout, MTLS: mtls, clients: auth.clients}
+}
+
+//Session is the authentication state of one connection.
+type Session struct {
+	auth      *Authenticator
+	peer      Peer
+	principal *Principal
+	nonce     string
+	clientID  string
+}
+
+//NewSession is to start the authentication of a connection.
+func (auth *Authenticator) NewSession(conn net.Conn) *Session {
+	return &Session{auth: auth, peer: PeerOf(conn)}
+}
+
+//checkMTLS is to authenticate by the client certificate, it is verified during the handshake of the first read.
+func (s *Session) checkMTLS(conn net.Conn) {
+	if s.principal != nil || !s.auth.MTLS {
+		return
+	}
+	s.peer = PeerOf(conn)
+	if !s.peer.Verified {
+		return
+	}
+	if cred, ok := s.auth.clients[s.peer.CommonName]; ok {
+		s.principal = &Principal{ID: s.peer.CommonName, Groups: cred.groups, Method: "mtls"}
+		utils.Log(s.peer.RemoteAddr, "authenticated by certificate as", s.principal.ID)
+	}
+}
+
+//Authenticated tells whether the connection may send requests.
+func (s *Session) Authenticated() bool {
+	return s.principal != nil || !s.auth.Required
+}
+
+//Context is the context of the requests of the connection, with the peer and the principal.
+func (s *Session) Context() context.Context {
+	ctx := NewPeerContext(context.Background(), s.peer)
+	if s.principal != nil {
+		ctx = NewPrincipalContext(ctx, s.principal)
+	}
+	return ctx
+}
+
+//Handle is to answer the authentication frames; handled is false for the other frames,
+//which must not be delivered while the session isn't authenticated. A failed authentication closes the connection.
+func (s *Session) Handle(conn net.Conn, postdata []byte) (handled bool, closeConn bool) {
+	s.checkMTLS(conn)
+	var entermsg Msg
+	if err := json.Unmarshal(postdata, &entermsg); err != nil {
+		if s.Authenticated() {
+			return false, false
+		}
+		conn.Write(errorResponse(0, ErrCodeUnauthorized, "authentication required", nil))
+		return true, true
+	}
+	rpcRequest := entermsg.Content
+	params, _ := rpcRequest.Params.(map[string]interface{})
+	str := func(key string) string {
+		v, _ := params[key].(string)
+		return v
+	}
+
+	switch rpcRequest.Method {
+	case utils.AuthChallengeMethod:
+		nonce := make([]byte, 16)
+		rand.Read(nonce)
+		s.nonce = hex.EncodeToString(nonce)
+		s.clientID = str("clientId")
+		conn.Write(authResult(rpcRequest.ID, map[string]interface{}{"nonce": s.nonce}))
+		return true, false
+	case utils.AuthMethod:
+		principal, err := s.auth.verify(str("clientId"), str("apiKey"), s.nonce, s.clientID, str("nonce"), str("signature"))
+		// a nonce answers one attempt only
+		s.nonce = ""
+		if err != nil {
+			utils.Log(s.peer.RemoteAddr, "authentication failed:", err)
+			conn.Write(errorResponse(rpcRequest.ID, ErrCodeUnauthorized, "authentication failed", nil))
+			return true, true
+		}
+		s.principal = principal
+		utils.Log(s.peer.RemoteAddr, "authenticated as", principal.ID, "by", principal.Method)
+		conn.Write(authResult(rpcRequest.ID, principal))
+		return true, false
+	}
+
+	if !s.Authenticated() {
+		utils.Log(s.peer.RemoteAddr, "sent", rpcRequest.Method, "before authentication")
+		conn.Write(errorResponse(rpcRequest.ID, ErrCodeUnauthorized, "authentication required", nil))
+		return true, true
+	}
+	return false, false
+}
+
+func (auth *Authenticator) verify(clientID string, apiKey string, nonce string, challenged string, answeredNonce string, signature string) (*Principal, error) {
+	cred, ok := auth.clients[clientID]
+	if !ok {
+		return nil, fmt.Errorf("unknown client %q", clientID)
+	}
+	if apiKey != "" {
+		if cred.apiKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cred.apiKey)) != 1 {
+			return nil, fmt.Errorf("wrong api key of %s", clientID)
+		}
+		return &Principal{ID: clientID, Groups: cred.groups, Method: "apikey"}, nil
+	}
+	if cred.secret == "" || nonce == "" || challenged != clientID || answeredNonce != nonce {
+		return nil, fmt.Errorf("no challenge for %s", clientID)
+	}
+	expected := utils.AuthSignature(cred.secret, nonce, clientID)
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(expected)) != 1 {
+		return nil, fmt.Errorf("wrong signature of %s", clientID)
+	}
+	return &Principal{ID: clientID, Groups: cred.groups, Method: "hmac"}, nil
+}
+
+func authResult(id uint, result interface{}) []byte {
+	respMsg, err := json.Marshal(&jsonrpc.RPCResponse{JSONRPC: "2.0", Result: result, ID: id})
+	utils.CheckError(err)
+	return respMsg
+}
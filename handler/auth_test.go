@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"goproxy4blockchain/utils"
+	"net"
+	"testing"
+)
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	auth, err := NewAuthenticator(section(t, `
+required: true
+mtls: true
+clients:
+  app-1: {apikey: k1-secret, groups: [farm]}
+  app-2: {secret: s2-secret}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+//serveAuth is the server side of the handshake on conn, as handleConnection does, until a frame isn't an auth frame
+//or the connection is closed; closed tells whether the session closed it.
+func serveAuth(conn net.Conn, session *Session) (closed bool) {
+	defer conn.Close()
+	buffer := make([]byte, 4096)
+	var rest []byte
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return false
+		}
+		messages, r, _ := utils.Unpack(append(rest, buffer[:n]...), 1<<20)
+		rest = r
+		for _, message := range messages {
+			handled, closeConn := session.Handle(conn, message)
+			if closeConn {
+				return true
+			}
+			if !handled {
+				return false
+			}
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		apiKey   string
+		secret   string
+		method   string
+	}{
+		{name: "api key", clientID: "app-1", apiKey: "k1-secret", method: "apikey"},
+		{name: "wrong api key", clientID: "app-1", apiKey: "k1-wrong"},
+		{name: "api key of a client with a secret", clientID: "app-2", apiKey: "s2-secret"},
+		{name: "hmac", clientID: "app-2", secret: "s2-secret", method: "hmac"},
+		{name: "wrong secret", clientID: "app-2", secret: "s2-wrong"},
+		{name: "unknown client", clientID: "app-3", apiKey: "k1-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			session := newTestAuthenticator(t).NewSession(server)
+			done := make(chan bool)
+			go func() { done <- serveAuth(server, session) }()
+			err := utils.Authenticate(client, tt.clientID, tt.apiKey, tt.secret)
+			client.Close()
+			closed := <-done
+			if tt.method == "" {
+				if err == nil || !closed || session.Authenticated() {
+					t.Errorf("err %v, closed %v, authenticated %v, want a failure closing the connection", err, closed, session.Authenticated())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			principal := PrincipalFromContext(session.Context())
+			if principal == nil || principal.ID != tt.clientID || principal.Method != tt.method {
+				t.Errorf("principal %+v, want %s by %s", principal, tt.clientID, tt.method)
+			}
+		})
+	}
+}
+
+//recorder is a connection keeping what the session writes.
+type recorder struct {
+	net.Conn
+	written bytes.Buffer
+	state   *tls.ConnectionState
+}
+
+func (r *recorder) Write(p []byte) (int, error) { return r.written.Write(p) }
+func (r *recorder) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+
+//response is the last response written, its result or error code.
+func (r *recorder) response(t *testing.T) (result map[string]interface{}, code int) {
+	t.Helper()
+	var reply struct {
+		Result map[string]interface{} `json:"result"`
+		Error  *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	decoder := json.NewDecoder(&r.written)
+	for decoder.More() {
+		if err := decoder.Decode(&reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply.Error != nil {
+		return nil, reply.Error.Code
+	}
+	return reply.Result, 0
+}
+
+func frameOf(method string, params map[string]interface{}) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"meta":    map[string]interface{}{"meta": "test"},
+		"content": map[string]interface{}{"method": method, "params": params, "id": 1, "jsonrpc": "2.0"},
+	})
+	return data
+}
+
+func TestNonceReuse(t *testing.T) {
+	auth := newTestAuthenticator(t)
+	conn := &recorder{}
+	session := auth.NewSession(conn)
+	session.Handle(conn, frameOf(utils.AuthChallengeMethod, map[string]interface{}{"clientId": "app-2"}))
+	result, _ := conn.response(t)
+	nonce, _ := result["nonce"].(string)
+	answer := map[string]interface{}{"clientId": "app-2", "nonce": nonce, "signature": utils.AuthSignature("s2-secret", nonce, "app-2")}
+	if _, closeConn := session.Handle(conn, frameOf(utils.AuthMethod, answer)); closeConn || !session.Authenticated() {
+		t.Fatal("the answer to the challenge is refused")
+	}
+
+	// the same answer again on the connection, and on another one after its own challenge
+	if _, closeConn := session.Handle(conn, frameOf(utils.AuthMethod, answer)); !closeConn {
+		t.Error("a nonce answered twice on a connection")
+	}
+	other := &recorder{}
+	replay := auth.NewSession(other)
+	replay.Handle(other, frameOf(utils.AuthChallengeMethod, map[string]interface{}{"clientId": "app-2"}))
+	if _, closeConn := replay.Handle(other, frameOf(utils.AuthMethod, answer)); !closeConn || replay.Authenticated() {
+		t.Error("the answer to another challenge is accepted")
+	}
+	if _, code := other.response(t); code != ErrCodeUnauthorized {
+		t.Errorf("code %d, want %d", code, ErrCodeUnauthorized)
+	}
+}
+
+func TestRequestBeforeAuthentication(t *testing.T) {
+	conn := &recorder{}
+	session := newTestAuthenticator(t).NewSession(conn)
+	handled, closeConn := session.Handle(conn, frameOf(StateMethod, map[string]interface{}{"channel": "vvtrip", "key": "0001"}))
+	if !handled || !closeConn {
+		t.Errorf("handled %v, close %v, want the request refused and the connection closed", handled, closeConn)
+	}
+	if _, code := conn.response(t); code != ErrCodeUnauthorized {
+		t.Errorf("code %d, want %d", code, ErrCodeUnauthorized)
+	}
+
+	// without auth required the requests go on
+	conn = &recorder{}
+	session = newTestAuthenticator(t).WithOptions(false, 0, false).NewSession(conn)
+	if handled, closeConn := session.Handle(conn, frameOf(StateMethod, nil)); handled || closeConn {
+		t.Errorf("handled %v, close %v without auth required", handled, closeConn)
+	}
+}
+
+//tlsRecorder is a recorder over TLS.
+type tlsRecorder struct {
+	recorder
+}
+
+func (r *tlsRecorder) ConnectionState() tls.ConnectionState { return *r.state }
+
+func TestMTLS(t *testing.T) {
+	cert := func(cn string) *x509.Certificate { return &x509.Certificate{Subject: pkix.Name{CommonName: cn}} }
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  string
+	}{
+		{name: "verified", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert("app-1")},
+			VerifiedChains: [][]*x509.Certificate{{cert("app-1")}}}, want: "app-1"},
+		{name: "not verified", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert("app-1")}}},
+		{name: "unknown client", state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert("app-9")},
+			VerifiedChains: [][]*x509.Certificate{{cert("app-9")}}}},
+		{name: "no certificate", state: &tls.ConnectionState{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &tlsRecorder{recorder{state: tt.state}}
+			session := newTestAuthenticator(t).NewSession(conn)
+			handled, closeConn := session.Handle(conn, frameOf(StateMethod, nil))
+			principal := PrincipalFromContext(session.Context())
+			if tt.want == "" {
+				if principal != nil || !closeConn {
+					t.Errorf("principal %+v, close %v, want the connection closed", principal, closeConn)
+				}
+				return
+			}
+			if handled || closeConn || principal == nil || principal.ID != tt.want || principal.Method != "mtls" {
+				t.Errorf("principal %+v, handled %v, close %v, want %s by mtls", principal, handled, closeConn, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"encoding/json"
+	"goproxy4blockchain/utils"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//the connections of the app clients are tracked for the admin interface, which lists and disconnects them.
+//记录当前客户端连接，供运维接口查询和强制断开。
+
+//Connection is an app client connection being served.
+type Connection struct {
+	ID          uint64
+	Listener    string
+	RemoteAddr  string
+	ConnectedAt time.Time
+
+	conn       net.Conn
+	mu         sync.Mutex
+	principal  string
+	lastActive time.Time
+	inFlight   int32
+}
+
+//ConnectionInfo is what the admin interface shows of a connection.
+type ConnectionInfo struct {
+	ID          uint64    `json:"id"`
+	Listener    string    `json:"listener"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	Principal   string    `json:"principal,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	IdleMs      int64     `json:"idleMs"`
+	InFlight    int32     `json:"inFlight"`
+}
+
+var connections = struct {
+	sync.Mutex
+	byConn map[net.Conn]*Connection
+	lastID uint64
+}{byConn: make(map[net.Conn]*Connection)}
+
+//TrackConnection is to list conn, accepted by the listener named listener, in the admin interface until Untrack.
+func TrackConnection(conn net.Conn, listener string) *Connection {
+	now := time.Now()
+	c := &Connection{Listener: listener, RemoteAddr: RemoteAddr(conn), ConnectedAt: now, conn: conn, lastActive: now}
+	connections.Lock()
+	defer connections.Unlock()
+	connections.lastID++
+	c.ID = connections.lastID
+	connections.byConn[conn] = c
+	return c
+}
+
+//Untrack is to remove the connection from the list, when it is closed.
+func (c *Connection) Untrack() {
+	connections.Lock()
+	defer connections.Unlock()
+	delete(connections.byConn, c.conn)
+}
+
+//Touch is to note the connection sent something.
+func (c *Connection) Touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastActive = time.Now()
+}
+
+//SetPrincipal is to note who the connection is authenticated as, nil is ignored.
+func (c *Connection) SetPrincipal(principal *Principal) {
+	if principal == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.principal = principal.ID
+}
+
+//connectionOf is the tracked connection of conn, nil if it isn't tracked.
+func connectionOf(conn net.Conn) *Connection {
+	connections.Lock()
+	defer connections.Unlock()
+	return connections.byConn[conn]
+}
+
+//requestStarted is to count a request in flight on the connection, the returned func is called when it is answered.
+func (c *Connection) requestStarted() func() {
+	atomic.AddInt32(&c.inFlight, 1)
+	return func() { atomic.AddInt32(&c.inFlight, -1) }
+}
+
+//Info is the state of the connection.
+func (c *Connection) Info() ConnectionInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConnectionInfo{
+		ID:          c.ID,
+		Listener:    c.Listener,
+		RemoteAddr:  c.RemoteAddr,
+		Principal:   c.principal,
+		ConnectedAt: c.ConnectedAt,
+		IdleMs:      int64(time.Since(c.lastActive) / time.Millisecond),
+		InFlight:    atomic.LoadInt32(&c.inFlight),
+	}
+}
+
+//Connections is the state of the connections, the oldest first.
+func Connections() []ConnectionInfo {
+	tracked := trackedConnections()
+	infos := make([]ConnectionInfo, 0, len(tracked))
+	for _, c := range tracked {
+		infos = append(infos, c.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+//Disconnect is to close the connection with id, ok is false if there is none.
+func Disconnect(id uint64) (info ConnectionInfo, ok bool) {
+	connections.Lock()
+	var found *Connection
+	for _, c := range connections.byConn {
+		if c.ID == id {
+			found = c
+			break
+		}
+	}
+	connections.Unlock()
+	if found == nil {
+		return info, false
+	}
+	found.conn.Close()
+	return found.Info(), true
+}
+
+//GoingAwayMethod is the json-rpc notification sent to the app clients when the proxy shuts down.
+const GoingAwayMethod = "server-going-away"
+
+var shuttingDown int32
+
+//BeginShutdown is to answer the requests still coming with ErrCodeGoingAway.
+func BeginShutdown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+//ShuttingDown tells whether the proxy is shutting down.
+func ShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+func trackedConnections() []*Connection {
+	connections.Lock()
+	defer connections.Unlock()
+	tracked := make([]*Connection, 0, len(connections.byConn))
+	for _, c := range connections.byConn {
+		tracked = append(tracked, c)
+	}
+	return tracked
+}
+
+//NotifyGoingAway is to tell the app clients the proxy shuts down, so they send no more requests and reconnect later;
+//the requests in flight are answered until drainTimeout. It returns the number of connections notified.
+func NotifyGoingAway(drainTimeout time.Duration) int {
+	notification, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  GoingAwayMethod,
+		"params": map[string]interface{}{
+			"reason":         "shutdown",
+			"drainTimeoutMs": int64(drainTimeout / time.Millisecond),
+		},
+	})
+	utils.CheckError(err)
+	notified := 0
+	for _, c := range trackedConnections() {
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := c.conn.Write(notification); err == nil {
+			notified++
+		}
+		c.conn.SetWriteDeadline(time.Time{})
+	}
+	return notified
+}
+
+//inFlight is the number of requests being answered on all the connections.
+func inFlight() int32 {
+	var n int32
+	for _, c := range trackedConnections() {
+		n += atomic.LoadInt32(&c.inFlight)
+	}
+	return n
+}
+
+//Drain is to wait for the requests in flight to be answered, false if some still aren't after timeout.
+func Drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for inFlight() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+//CloseConnections is to close all the app client connections.
+func CloseConnections() {
+	for _, c := range trackedConnections() {
+		c.conn.Close()
+	}
+}
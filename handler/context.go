@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+//every Msg carries a context, the controllers get who sent it by PeerFromContext(message.Context()).
+//每个Msg带有context，controller可从中取得客户端身份（mTLS证书的subject）。
+
+//Peer is who is at the other end of a connection.
+type Peer struct {
+	RemoteAddr string `json:"remoteAddr"`
+	// Subject and CommonName are taken from the client certificate, they are empty without mTLS.
+	Subject    string `json:"subject,omitempty"`
+	CommonName string `json:"commonName,omitempty"`
+	// Verified is set when the client certificate was verified against clientcafile.
+	Verified bool `json:"verified"`
+}
+
+type peerKey struct{}
+
+//NewPeerContext returns a copy of ctx carrying peer.
+func NewPeerContext(ctx context.Context, peer Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, peer)
+}
+
+//PeerFromContext is the peer of a request, ok is false if there is none.
+func PeerFromContext(ctx context.Context) (peer Peer, ok bool) {
+	peer, ok = ctx.Value(peerKey{}).(Peer)
+	return peer, ok
+}
+
+//RemoteAddr is the address of the client of conn; a client of a unix socket has none, it is the socket then.
+func RemoteAddr(conn net.Conn) string {
+	if addr := conn.RemoteAddr(); addr != nil {
+		if remote := addr.String(); remote != "" && remote != "@" {
+			return remote
+		}
+	}
+	return "unix:" + conn.LocalAddr().String()
+}
+
+//tlsConnection is a connection over TLS, a *tls.Conn or a websocket over https.
+type tlsConnection interface {
+	ConnectionState() tls.ConnectionState
+}
+
+//PeerOf is to get the identity of the client of a connection, from its certificate over TLS.
+func PeerOf(conn net.Conn) Peer {
+	peer := Peer{RemoteAddr: RemoteAddr(conn)}
+	tlsConn, ok := conn.(tlsConnection)
+	if !ok {
+		return peer
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		peer.Subject = cert.Subject.String()
+		peer.CommonName = cert.Subject.CommonName
+		peer.Verified = len(state.VerifiedChains) > 0
+	}
+	return peer
+}
+
+//Context is the context of the message, never nil.
+func (m Msg) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+//WithContext returns a copy of the message with ctx.
+func (m Msg) WithContext(ctx context.Context) Msg {
+	m.ctx = ctx
+	return m
+}
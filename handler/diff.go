@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+//FieldChange is one difference between two versions of a record.
+//Kind is "+" for an added, "-" for a removed and "~" for a changed field.
+type FieldChange struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+func (fc FieldChange) String() string {
+	switch fc.Kind {
+	case "+":
+		return fmt.Sprintf("+ %s: %s", fc.Path, fc.New)
+	case "-":
+		return fmt.Sprintf("- %s: %s", fc.Path, fc.Old)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", fc.Path, fc.Old, fc.New)
+}
+
+//DiffJSON is to compare two json documents field by field, old may be empty for a new record.
+func DiffJSON(old string, new string) ([]FieldChange, error) {
+	var before, after interface{}
+	if old != "" {
+		if err := json.Unmarshal([]byte(old), &before); err != nil {
+			// the stored value is not json, show it as a whole
+			before = old
+		}
+	}
+	if err := json.Unmarshal([]byte(new), &after); err != nil {
+		return nil, err
+	}
+	var changes []FieldChange
+	diffNode("", before, after, &changes)
+	return changes, nil
+}
+
+func diffNode(path string, before interface{}, after interface{}, changes *[]FieldChange) {
+	switch a := after.(type) {
+	case map[string]interface{}:
+		b, ok := before.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range a {
+			keys[k] = true
+		}
+		for k := range b {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			diffNode(joinPath(path, k), b[k], a[k], changes)
+		}
+		return
+	case []interface{}:
+		b, ok := before.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(a) || i < len(b); i++ {
+			var bi, ai interface{}
+			if i < len(b) {
+				bi = b[i]
+			}
+			if i < len(a) {
+				ai = a[i]
+			}
+			diffNode(fmt.Sprintf("%s[%d]", path, i), bi, ai, changes)
+		}
+		return
+	}
+
+	if path == "" {
+		path = "(record)"
+	}
+	switch {
+	case before == nil && after == nil:
+	case before == nil:
+		*changes = append(*changes, FieldChange{Kind: "+", Path: path, New: showJSON(after)})
+	case after == nil:
+		*changes = append(*changes, FieldChange{Kind: "-", Path: path, Old: showJSON(before)})
+	case showJSON(before) != showJSON(after):
+		*changes = append(*changes, FieldChange{Kind: "~", Path: path, Old: showJSON(before), New: showJSON(after)})
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func showJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,351 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"goproxy4blockchain/utils"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+/* Encryption of the sensitive fields of the records, in the encryption section of config.yaml:
+   encryption:
+     masterkey: ./conf/master.key       # 32 bytes in hex or base64, e.g. openssl rand -hex 32 > conf/master.key
+     keyid: master-1                    # the sha256 of the master key by default
+     fields:                            # json paths of each record kind, a path steps into every item of a list
+       seed: [registeredNumber, unifiedSocialCreditCode]
+       product: [items.measuredValue]
+   Each record gets a random data key; the fields are encrypted with it by AES-GCM and it is wrapped by the master key:
+     {"version": 1, "record": {"items": [{"measuredValue": "enc:v1:..."}]},
+      "encryption": {"alg": "A256GCM", "keyId": "master-1", "dek": "...", "fields": ["items.0.measuredValue"]}}
+   The fields are decrypted in the responses for the principals the acl allows the "decrypt" method on the channel and key.
+   The kind of a write is the "kind" param, or detected from its fields; a write whose kind is unknown is refused rather
+   than stored in clear.
+   敏感字段加密：按记录类型配置需加密的字段，仅对ACL授权decrypt的客户端透明解密。
+*/
+
+//DecryptMethod is the acl method allowing a principal to read the encrypted fields, it isn't a chain method.
+const DecryptMethod = "decrypt"
+
+//encryptedPrefix marks an encrypted field value.
+const encryptedPrefix = "enc:v1:"
+
+//RecordEncryption is stored in a versioned record whose fields are encrypted.
+type RecordEncryption struct {
+	Alg   string `json:"alg"`
+	KeyID string `json:"keyId"`
+	// DEK is the data key of the record wrapped by the master key, base64 of nonce and sealed key.
+	DEK    string   `json:"dek"`
+	Fields []string `json:"fields"`
+}
+
+//FieldCipher encrypts the configured fields of the records.
+type FieldCipher struct {
+	KeyID  string
+	Fields map[string][]string
+	// the acl in use decides who may read the fields, nobody without acl
+	master cipher.AEAD
+}
+
+var fieldCipher *FieldCipher
+
+//UseFieldCipher is to encrypt the writes and decrypt the responses with c, nil turns encryption off.
+func UseFieldCipher(c *FieldCipher) {
+	fieldCipher = c
+}
+
+//NewFieldCipher is to read the encryption section of the config, nil section means no encryption.
+func NewFieldCipher(section map[interface{}]interface{}) (*FieldCipher, error) {
+	if section == nil {
+		return nil, nil
+	}
+	if _, ok := section["masterkey"]; !ok {
+		return nil, fmt.Errorf("encryption needs masterkey")
+	}
+	path := utils.GetElement("masterkey", section)
+	key, err := readMasterKey(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &FieldCipher{Fields: make(map[string][]string)}
+	if c.master, err = newGCM(key); err != nil {
+		return nil, err
+	}
+	if _, ok := section["keyid"]; ok {
+		c.KeyID = utils.GetElement("keyid", section)
+	} else {
+		sum := sha256.Sum256(key)
+		c.KeyID = hex.EncodeToString(sum[:8])
+	}
+	for kind, paths := range utils.GetSection("fields", section) {
+		if _, ok := RecordKinds[fmt.Sprint(kind)]; !ok {
+			return nil, fmt.Errorf("encryption fields: unknown record kind %v", kind)
+		}
+		c.Fields[fmt.Sprint(kind)] = stringList(paths)
+	}
+	return c, nil
+}
+
+//readMasterKey is to read a 32 bytes key written in hex, base64 or as it is.
+func readMasterKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	return nil, fmt.Errorf("masterkey %s: expect 32 bytes in hex or base64", path)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//sealWith is to encrypt with a random nonce, put before the ciphertext.
+func sealWith(aead cipher.AEAD, plaintext []byte, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+//openWith is to decrypt what sealWith sealed.
+func openWith(aead cipher.AEAD, sealed []byte, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+}
+
+//fieldAAD binds the ciphertext of a field to where it is stored, so it can't be moved to another record or field.
+func fieldAAD(channel string, key string, path string) []byte {
+	return []byte(strconv.Quote(channel) + "\n" + strconv.Quote(key) + "\n" + path)
+}
+
+//walkPath is to call fn on the values at path in node, a step into a list goes into every item unless it is an index;
+//fn returns the new value. concrete is the path with the indexes of the items.
+func walkPath(node interface{}, steps []string, concrete string, fn func(concrete string, value interface{}) interface{}) interface{} {
+	if len(steps) == 0 {
+		return fn(concrete, node)
+	}
+	switch v := node.(type) {
+	case map[string]interface{}:
+		child, ok := v[steps[0]]
+		if !ok || child == nil {
+			return node
+		}
+		v[steps[0]] = walkPath(child, steps[1:], joinPath(concrete, steps[0]), fn)
+	case []interface{}:
+		if i, err := strconv.Atoi(steps[0]); err == nil {
+			if i >= 0 && i < len(v) {
+				v[i] = walkPath(v[i], steps[1:], joinPath(concrete, steps[0]), fn)
+			}
+			return node
+		}
+		for i := range v {
+			v[i] = walkPath(v[i], steps, joinPath(concrete, strconv.Itoa(i)), fn)
+		}
+	}
+	return node
+}
+
+func decodeRecord(raw json.RawMessage) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var record interface{}
+	err := decoder.Decode(&record)
+	return record, err
+}
+
+//RecordKindError is a write whose record kind isn't known, its fields can't be told apart.
+type RecordKindError struct {
+	Key  string
+	Kind string
+}
+
+func (e *RecordKindError) Error() string {
+	if e.Kind == "" {
+		return fmt.Sprintf("the record kind of %s can't be detected, set the kind param", e.Key)
+	}
+	return fmt.Sprintf("unknown record kind %q of %s", e.Kind, e.Key)
+}
+
+//EncryptRecord is to encrypt the configured fields of a record about to be written under key of channel;
+//kind is the record kind, detected when it is "".
+func (c *FieldCipher) EncryptRecord(channel string, key string, kind string, vr *VersionedRecord) error {
+	if kind == "" {
+		kind = DetectRecordKind(vr.Record)
+	}
+	if _, ok := RecordKinds[kind]; !ok {
+		return &RecordKindError{Key: key, Kind: kind}
+	}
+	paths := c.Fields[kind]
+	if len(paths) == 0 {
+		return nil
+	}
+	record, err := decodeRecord(vr.Record)
+	if err != nil {
+		return err
+	}
+	dek := make([]byte, 32)
+	if _, err = rand.Read(dek); err != nil {
+		return err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return err
+	}
+
+	var encrypted []string
+	for _, path := range paths {
+		record = walkPath(record, strings.Split(path, "."), "", func(concrete string, value interface{}) interface{} {
+			if err != nil {
+				return value
+			}
+			plaintext, e := json.Marshal(value)
+			if e != nil {
+				err = e
+				return value
+			}
+			sealed, e := sealWith(aead, plaintext, fieldAAD(channel, key, concrete))
+			if e != nil {
+				err = e
+				return value
+			}
+			encrypted = append(encrypted, concrete)
+			return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+		})
+	}
+	if err != nil || len(encrypted) == 0 {
+		return err
+	}
+	wrapped, err := sealWith(c.master, dek, []byte(c.KeyID))
+	if err != nil {
+		return err
+	}
+	if vr.Record, err = json.Marshal(record); err != nil {
+		return err
+	}
+	vr.Encryption = &RecordEncryption{Alg: "A256GCM", KeyID: c.KeyID, DEK: base64.StdEncoding.EncodeToString(wrapped), Fields: encrypted}
+	return nil
+}
+
+//DecryptValue is to decrypt the fields of a stored value, the value is returned as it is when there is nothing to decrypt.
+func (c *FieldCipher) DecryptValue(channel string, key string, value string) (string, error) {
+	var vr VersionedRecord
+	if json.Unmarshal([]byte(value), &vr) != nil || vr.Encryption == nil || vr.Record == nil {
+		return value, nil
+	}
+	if vr.Encryption.KeyID != c.KeyID {
+		return value, fmt.Errorf("%s is encrypted with unknown master key %s", key, vr.Encryption.KeyID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(vr.Encryption.DEK)
+	if err != nil {
+		return value, err
+	}
+	dek, err := openWith(c.master, wrapped, []byte(c.KeyID))
+	if err != nil {
+		return value, fmt.Errorf("unwrap data key of %s: %v", key, err)
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return value, err
+	}
+	record, err := decodeRecord(vr.Record)
+	if err != nil {
+		return value, err
+	}
+	for _, path := range vr.Encryption.Fields {
+		record = walkPath(record, strings.Split(path, "."), "", func(concrete string, field interface{}) interface{} {
+			text, ok := field.(string)
+			if err != nil || !ok || !strings.HasPrefix(text, encryptedPrefix) {
+				return field
+			}
+			sealed, e := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, encryptedPrefix))
+			if e != nil {
+				err = e
+				return field
+			}
+			plaintext, e := openWith(aead, sealed, fieldAAD(channel, key, concrete))
+			if e != nil {
+				err = fmt.Errorf("decrypt %s of %s: %v", concrete, key, e)
+				return field
+			}
+			return json.RawMessage(plaintext)
+		})
+	}
+	if err != nil {
+		return value, err
+	}
+	if vr.Record, err = json.Marshal(record); err != nil {
+		return value, err
+	}
+	decrypted, err := json.Marshal(vr)
+	if err != nil {
+		return value, err
+	}
+	return string(decrypted), nil
+}
+
+//mayDecrypt tells whether principal may read the encrypted fields of key of channel, the decision is logged for audit.
+func (c *FieldCipher) mayDecrypt(ctx context.Context, principal *Principal, channel string, key string) bool {
+	acl := CurrentACL()
+	if c == nil || acl == nil {
+		return false
+	}
+	decision := acl.Check(principal, channel, key, DecryptMethod)
+	utils.LoggerFrom(ctx).Info(decision)
+	return decision.Allow
+}
+
+//decryptResponse is to decrypt the values in the response of source-state or source-transactions for principal.
+func (c *FieldCipher) decryptResponse(ctx context.Context, principal *Principal, method string, params *MethodParams, result interface{}) {
+	if !c.mayDecrypt(ctx, principal, params.Channel, params.Key) {
+		return
+	}
+	decrypt := func(entry map[string]interface{}, field string) {
+		if value, ok := entry[field].(string); ok {
+			decrypted, err := c.DecryptValue(params.Channel, params.Key, value)
+			if err != nil {
+				utils.Log("xxx decryptResponse()", err)
+			}
+			entry[field] = decrypted
+		}
+	}
+	switch v := result.(type) {
+	case map[string]interface{}:
+		if method == StateMethod {
+			decrypt(v, "state")
+		}
+	case []interface{}:
+		if method == TransactionsMethod {
+			for _, tx := range v {
+				if entry, ok := tx.(map[string]interface{}); ok {
+					decrypt(entry, "value")
+				}
+			}
+		}
+	}
+}
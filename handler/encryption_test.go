@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+//section is a section of config.yaml as the constructors read it.
+func section(t *testing.T, text string) map[interface{}]interface{} {
+	t.Helper()
+	var s map[interface{}]interface{}
+	if err := yaml.Unmarshal([]byte(text), &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestCipher(t *testing.T) *FieldCipher {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("ab", 32)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewFieldCipher(section(t, `
+masterkey: `+path+`
+keyid: master-1
+fields:
+  seed: [registeredNumber]
+  product: [items.measuredValue]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+//encrypt is the stored value of record written under key of vvtrip.
+func encrypt(t *testing.T, c *FieldCipher, key string, kind string, record string) string {
+	t.Helper()
+	vr := VersionedRecord{Amendment: Amendment{Version: 1}, Record: json.RawMessage(record)}
+	if err := c.EncryptRecord("vvtrip", key, kind, &vr); err != nil {
+		t.Fatal(err)
+	}
+	value, err := json.Marshal(vr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(value)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	c := newTestCipher(t)
+	tests := []struct {
+		name   string
+		kind   string
+		record string
+		fields []string
+		clear  []string
+	}{
+		{name: "seed", record: `{"seedValidationNumber":"S-1","registeredNumber":"REG-1"}`,
+			fields: []string{"registeredNumber"}, clear: []string{"REG-1"}},
+		{name: "product items", record: `{"items":[{"inspectionProject":"水份","measuredValue":"12.1%"},{"inspectionProject":"净度","measuredValue":"99%"}]}`,
+			fields: []string{"items.0.measuredValue", "items.1.measuredValue"}, clear: []string{"12.1%", "99%"}},
+		{name: "kind stated", kind: "seed", record: `{"registeredNumber":"REG-2"}`,
+			fields: []string{"registeredNumber"}, clear: []string{"REG-2"}},
+		{name: "kind without fields", kind: "organic", record: `{"registeredNumber":"REG-3"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := encrypt(t, c, "0001", tt.kind, tt.record)
+			for _, text := range tt.clear {
+				if strings.Contains(value, text) {
+					t.Errorf("%q is stored in clear: %s", text, value)
+				}
+			}
+			var vr VersionedRecord
+			if err := json.Unmarshal([]byte(value), &vr); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.fields) == 0 {
+				if vr.Encryption != nil {
+					t.Errorf("encrypted %v", vr.Encryption.Fields)
+				}
+			} else if vr.Encryption == nil || strings.Join(vr.Encryption.Fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("encryption %+v, want the fields %v", vr.Encryption, tt.fields)
+			}
+
+			decrypted, err := c.DecryptValue("vvtrip", "0001", value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, record := UnwrapRecord(decrypted)
+			var got, want interface{}
+			json.Unmarshal(record, &got)
+			json.Unmarshal([]byte(tt.record), &want)
+			if gotJSON, _ := json.Marshal(got); string(gotJSON) != mustJSON(want) {
+				t.Errorf("decrypted %s, want %s", gotJSON, mustJSON(want))
+			}
+		})
+	}
+}
+
+func mustJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestEncryptUnknownKind(t *testing.T) {
+	c := newTestCipher(t)
+	for _, kind := range []string{"", "bogus"} {
+		vr := VersionedRecord{Record: json.RawMessage(`{"registeredNumber":"REG-1"}`)}
+		err := c.EncryptRecord("vvtrip", "0001", kind, &vr)
+		if _, ok := err.(*RecordKindError); !ok {
+			t.Errorf("kind %q: err = %v, want a RecordKindError", kind, err)
+		}
+	}
+}
+
+func TestDecryptAAD(t *testing.T) {
+	c := newTestCipher(t)
+	value := encrypt(t, c, "0001", "", `{"items":[{"measuredValue":"12.1%"},{"measuredValue":"99%"}]}`)
+	if _, err := c.DecryptValue("vvtrip", "0002", value); err == nil {
+		t.Error("decrypted under another key")
+	}
+	if _, err := c.DecryptValue("other", "0001", value); err == nil {
+		t.Error("decrypted in another channel")
+	}
+
+	// the ciphertexts of two fields swapped
+	var vr VersionedRecord
+	json.Unmarshal([]byte(value), &vr)
+	var record map[string][]map[string]string
+	json.Unmarshal(vr.Record, &record)
+	items := record["items"]
+	items[0]["measuredValue"], items[1]["measuredValue"] = items[1]["measuredValue"], items[0]["measuredValue"]
+	vr.Record, _ = json.Marshal(record)
+	if _, err := c.DecryptValue("vvtrip", "0001", mustJSON(vr)); err == nil {
+		t.Error("decrypted a field moved to another item")
+	}
+}
+
+func TestDecryptACL(t *testing.T) {
+	c := newTestCipher(t)
+	value := encrypt(t, c, "0001", "", `{"seedValidationNumber":"S-1","registeredNumber":"REG-1"}`)
+	acl, err := NewACL(section(t, `
+rules:
+  - principals: [auditor]
+    channels: [vvtrip]
+    methods: [decrypt, source-state]
+  - principals: "*"
+    methods: [source-state]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseACL(acl)
+	defer UseACL(nil)
+
+	tests := []struct {
+		principal *Principal
+		channel   string
+		decrypted bool
+	}{
+		{principal: &Principal{ID: "auditor"}, channel: "vvtrip", decrypted: true},
+		{principal: &Principal{ID: "auditor"}, channel: "other"},
+		{principal: &Principal{ID: "app-1"}, channel: "vvtrip"},
+		{principal: nil, channel: "vvtrip"},
+	}
+	for _, tt := range tests {
+		result := map[string]interface{}{"state": value}
+		params := &MethodParams{Channel: tt.channel, Key: "0001"}
+		c.decryptResponse(context.Background(), tt.principal, StateMethod, params, result)
+		state := result["state"].(string)
+		if got := strings.Contains(state, "REG-1"); got != tt.decrypted {
+			t.Errorf("principal %v on %s: decrypted %v, want %v", tt.principal, tt.channel, got, tt.decrypted)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goproxy4blockchain/utils"
+	"sort"
+	"sync"
+)
+
+/* Amendment of records: a corrected report used to overwrite the key and the link to the old one was lost.
+   Now every write through the proxy is wrapped in a VersionedRecord carrying the version, the tx_id it replaces and the reason:
+     {"version": 2, "prevTxId": "f3c691ec...", "reason": "lab corrected 水份", "record": {...}}
+   The lineage of a key is rebuilt from source-transactions by following prevTxId from the latest write.
+
+   记录修订：每次写入都带上版本号、上一版本的tx_id和修改原因，通过source-history可查看版本链及字段差异。
+*/
+
+//HistoryMethod is answered by the proxy itself with the version lineage of a key.
+const HistoryMethod = "source-history"
+
+//Amendment is what every write carries besides the record.
+type Amendment struct {
+	Version  int    `json:"version"`
+	PrevTxID string `json:"prevTxId,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+//VersionedRecord is the value stored on the chain.
+type VersionedRecord struct {
+	Amendment
+	Record json.RawMessage `json:"record"`
+	// Encryption is set when fields of the record are encrypted, see encryption.go.
+	Encryption *RecordEncryption `json:"encryption,omitempty"`
+	// Signature is set when the proxy signs the writes, see signing.go.
+	Signature *RecordSignature `json:"signature,omitempty"`
+}
+
+//RecordVersion is one version of a record in its history.
+type RecordVersion struct {
+	Amendment
+	TxID      string          `json:"tx_id"`
+	Timestamp Timestamp       `json:"timestamp"`
+	Record    json.RawMessage `json:"record"`
+	Changes   []FieldChange   `json:"changes"`
+	// Legacy is set for values written before versioning, their order is taken from the timestamps.
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+//RecordHistory is the lineage of a key, oldest version first.
+type RecordHistory struct {
+	Channel  string          `json:"channel"`
+	Key      string          `json:"key"`
+	Versions []RecordVersion `json:"versions"`
+	// Detached are writes that are not on the lineage of the latest version, e.g. two amendments of the same version.
+	Detached []RecordVersion `json:"detached,omitempty"`
+}
+
+//UnwrapRecord is to split a stored value into its amendment and the record, values written before versioning have version 0.
+func UnwrapRecord(value string) (Amendment, json.RawMessage) {
+	var envelope struct {
+		Version *int            `json:"version"`
+		Record  json.RawMessage `json:"record"`
+	}
+	if json.Unmarshal([]byte(value), &envelope) == nil && envelope.Version != nil && envelope.Record != nil {
+		var vr VersionedRecord
+		json.Unmarshal([]byte(value), &vr)
+		return vr.Amendment, vr.Record
+	}
+	if json.Valid([]byte(value)) {
+		return Amendment{}, json.RawMessage(value)
+	}
+	raw, _ := json.Marshal(value)
+	return Amendment{}, raw
+}
+
+//versionsOf is to parse the transactions of a key, sorted by time.
+func versionsOf(transactions []ResultTransaction) []RecordVersion {
+	sort.SliceStable(transactions, func(i, j int) bool {
+		ti, tj := transactions[i].Timestamp, transactions[j].Timestamp
+		return ti.Seconds < tj.Seconds || (ti.Seconds == tj.Seconds && ti.Nanos < tj.Nanos)
+	})
+	versions := make([]RecordVersion, 0, len(transactions))
+	for i, tx := range transactions {
+		amendment, record := UnwrapRecord(tx.Value)
+		v := RecordVersion{Amendment: amendment, TxID: tx.Tx_id, Timestamp: tx.Timestamp, Record: record}
+		if amendment.Version == 0 {
+			v.Legacy = true
+			v.Version = i + 1
+			if i > 0 {
+				v.PrevTxID = transactions[i-1].Tx_id
+			}
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+//buildLineage is to follow prevTxId from the latest version back to the first one.
+func buildLineage(versions []RecordVersion) (lineage []RecordVersion, detached []RecordVersion) {
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	byTx := make(map[string]int)
+	for i, v := range versions {
+		byTx[v.TxID] = i
+	}
+	onLineage := make(map[int]bool)
+	for i := len(versions) - 1; i >= 0 && !onLineage[i]; {
+		onLineage[i] = true
+		lineage = append(lineage, versions[i])
+		prev, ok := byTx[versions[i].PrevTxID]
+		if !ok {
+			break
+		}
+		i = prev
+	}
+	for l, r := 0, len(lineage)-1; l < r; l, r = l+1, r-1 {
+		lineage[l], lineage[r] = lineage[r], lineage[l]
+	}
+	for i, v := range versions {
+		if !onLineage[i] {
+			detached = append(detached, v)
+		}
+	}
+
+	var previous string
+	for i := range lineage {
+		lineage[i].Changes, _ = DiffJSON(previous, string(lineage[i].Record))
+		previous = string(lineage[i].Record)
+	}
+	return lineage, detached
+}
+
+//History is to rebuild the version lineage of a record from its transactions,
+//the encrypted fields are decrypted if principal may read them.
+func History(ctx context.Context, channel string, key string, principal *Principal) (*RecordHistory, error) {
+	transactions, err := transactionsOf(ctx, channel, key)
+	if err != nil {
+		return nil, err
+	}
+	if fieldCipher.mayDecrypt(ctx, principal, channel, key) {
+		for i := range transactions {
+			if transactions[i].Value, err = fieldCipher.DecryptValue(channel, key, transactions[i].Value); err != nil {
+				utils.Log("xxx History()", err)
+			}
+		}
+	}
+	history := &RecordHistory{Channel: channel, Key: key}
+	history.Versions, history.Detached = buildLineage(versionsOf(transactions))
+	return history, nil
+}
+
+func transactionsOf(ctx context.Context, channel string, key string) ([]ResultTransaction, error) {
+	rpcResp, err := callUpstream(ctx, TransactionsMethod, &MethodParams{Channel: channel, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	var transactions []ResultTransaction
+	if err = rpcResp.GetObject(&transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+//VersionConflictError is returned when a write is based on an outdated version.
+type VersionConflictError struct {
+	Key     string
+	Writing int
+	Next    int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict: writing version %d but the next version of %s is %d", e.Writing, e.Key, e.Next)
+}
+
+//keyLock is held by the write of a key, users are the writes holding or waiting for it.
+type keyLock struct {
+	sync.Mutex
+	users int
+}
+
+var keyLocks = struct {
+	sync.Mutex
+	byKey map[string]*keyLock
+}{byKey: make(map[string]*keyLock)}
+
+//lockKey is to serialize the writes of channel and key, from reading the latest version to the answer of the chain.
+func lockKey(channel string, key string) (unlock func()) {
+	id := channel + "\x00" + key
+	keyLocks.Lock()
+	l, ok := keyLocks.byKey[id]
+	if !ok {
+		l = &keyLock{}
+		keyLocks.byKey[id] = l
+	}
+	l.users++
+	keyLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		keyLocks.Lock()
+		defer keyLocks.Unlock()
+		if l.users--; l.users == 0 {
+			delete(keyLocks.byKey, id)
+		}
+	}
+}
+
+//amendWrite is to wrap the value of a write into a VersionedRecord following the latest version of the key.
+//If the client sent the version it is writing, a write based on an outdated version is refused.
+//The caller holds lockKey until the chain answered, so two writes of a key through the proxy can't both be the same
+//version; the writes through another proxy or straight to the chain aren't seen, for them the check is advisory.
+func amendWrite(ctx context.Context, params *MethodParams) error {
+	transactions, err := transactionsOf(ctx, params.Channel, params.Key)
+	if err != nil {
+		return err
+	}
+	lineage, _ := buildLineage(versionsOf(transactions))
+
+	vr := VersionedRecord{Amendment: Amendment{Version: 1, Reason: params.Reason}}
+	if len(lineage) > 0 {
+		latest := lineage[len(lineage)-1]
+		vr.Version = latest.Version + 1
+		vr.PrevTxID = latest.TxID
+	}
+	if params.Version != 0 && params.Version != vr.Version {
+		return &VersionConflictError{Key: params.Key, Writing: params.Version, Next: vr.Version}
+	}
+	_, vr.Record = UnwrapRecord(params.Value)
+	// the signature covers the encrypted fields
+	if fieldCipher != nil {
+		if err = fieldCipher.EncryptRecord(params.Channel, params.Key, params.Kind, &vr); err != nil {
+			return err
+		}
+	}
+	if keyring != nil && keyring.private != nil {
+		if err = keyring.Sign(params.Channel, params.Key, &vr); err != nil {
+			return err
+		}
+		params.Signature, params.KeyID = vr.Signature.Value, vr.Signature.KeyID
+	}
+
+	value, err := json.Marshal(vr)
+	if err != nil {
+		return err
+	}
+	params.Value = string(value)
+	// reason and version are kept in the value, they are not chain params
+	params.Reason = ""
+	params.Version = 0
+	params.Kind = ""
+	return nil
+}
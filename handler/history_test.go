@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"encoding/json"
+	"goproxy4blockchain/jsonrpc"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//recordedTransactions are the transactions of a source-transactions response of the upstream in testdata.
+func recordedTransactions(t *testing.T, name string) []ResultTransaction {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "transactions", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rpcResp jsonrpc.RPCResponse
+	if err = json.Unmarshal(data, &rpcResp); err != nil {
+		t.Fatal(err)
+	}
+	var transactions []ResultTransaction
+	if err = rpcResp.GetObject(&transactions); err != nil {
+		t.Fatal(err)
+	}
+	return transactions
+}
+
+//versionLine is a version as tx_id, version, prevTxId and changes, e.g. "b2 v2 <a1 ~ qulity".
+func versionLine(v RecordVersion) string {
+	line := v.TxID + " v" + strconv.Itoa(v.Version)
+	if v.PrevTxID != "" {
+		line += " <" + v.PrevTxID
+	}
+	if v.Legacy {
+		line += " legacy"
+	}
+	for _, change := range v.Changes {
+		line += " " + change.Kind + " " + change.Path
+	}
+	return line
+}
+
+func TestLineage(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		lineage  []string
+		detached []string
+	}{
+		{fixture: "empty.json"},
+		{fixture: "legacy.json", lineage: []string{
+			"9a3c v1 legacy + (record)",
+			"0b1f v2 <9a3c legacy ~ (record)",
+		}},
+		{fixture: "amended.json", lineage: []string{
+			"a1 v1 legacy + (record)",
+			"b2 v2 <a1 ~ qulity",
+			"c3 v3 <b2 + origin",
+		}},
+		{fixture: "detached.json", lineage: []string{
+			"x1 v1 + (record)",
+			"x3 v2 <x1 - qulity",
+		}, detached: []string{"x2 v2 <x1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			lineage, detached := buildLineage(versionsOf(recordedTransactions(t, tt.fixture)))
+			var got, gotDetached []string
+			for _, v := range lineage {
+				got = append(got, versionLine(v))
+			}
+			for _, v := range detached {
+				gotDetached = append(gotDetached, versionLine(v))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.lineage, "\n") {
+				t.Errorf("lineage\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.lineage, "\n"))
+			}
+			if strings.Join(gotDetached, "\n") != strings.Join(tt.detached, "\n") {
+				t.Errorf("detached %q, want %q", gotDetached, tt.detached)
+			}
+		})
+	}
+}
+
+func TestLineageRecords(t *testing.T) {
+	lineage, _ := buildLineage(versionsOf(recordedTransactions(t, "amended.json")))
+	if lineage[1].Reason != "lab corrected 水份" {
+		t.Errorf("reason %q", lineage[1].Reason)
+	}
+	if string(lineage[2].Record) != `{"seedValidationNumber":"S-1","qulity":"纯度≥98%","origin":"甘肃"}` {
+		t.Errorf("record %s", lineage[2].Record)
+	}
+	change := lineage[1].Changes[0]
+	if change.Old != `"纯度≥96%"` || change.New != `"纯度≥98%"` {
+		t.Errorf("change %s", change)
+	}
+	legacy, _ := buildLineage(versionsOf(recordedTransactions(t, "legacy.json")))
+	if string(legacy[1].Record) != `"plain text"` {
+		t.Errorf("a value which isn't json is %s", legacy[1].Record)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		changes []string
+		err     bool
+	}{
+		{name: "new record", old: "", new: `{"a":1}`, changes: []string{"+ (record): {\"a\":1}"}},
+		{name: "same", old: `{"a":1,"b":[1,2]}`, new: `{"b":[1,2],"a":1}`},
+		{name: "added, removed and changed", old: `{"a":1,"b":"x"}`, new: `{"a":2,"c":true}`,
+			changes: []string{"~ a: 1 -> 2", "- b: \"x\"", "+ c: true"}},
+		{name: "nested", old: `{"m":{"x":1,"y":{"z":"1"}}}`, new: `{"m":{"x":1,"y":{"z":"2"}}}`,
+			changes: []string{"~ m.y.z: \"1\" -> \"2\""}},
+		{name: "list items", old: `{"items":[{"v":"1"},{"v":"2"}]}`, new: `{"items":[{"v":"1"},{"v":"3"},{"v":"4"}]}`,
+			changes: []string{"~ items[1].v: \"2\" -> \"3\"", "+ items[2]: {\"v\":\"4\"}"}},
+		{name: "type changed", old: `{"a":{"b":1}}`, new: `{"a":[1]}`, changes: []string{"~ a: {\"b\":1} -> [1]"}},
+		{name: "old value isn't json", old: "plain text", new: `{"a":1}`, changes: []string{"~ (record): \"plain text\" -> {\"a\":1}"}},
+		{name: "new value isn't json", old: `{"a":1}`, new: "plain text", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := DiffJSON(tt.old, tt.new)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v", err)
+			}
+			var got []string
+			for _, change := range changes {
+				got = append(got, change.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.changes, "\n") {
+				t.Errorf("changes %q, want %q", got, tt.changes)
+			}
+		})
+	}
+}
+
+func TestLockKey(t *testing.T) {
+	var wg sync.WaitGroup
+	holding := make(map[string]int)
+	var mu sync.Mutex
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"0001", "0002"} {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				unlock := lockKey("vvtrip", key)
+				defer unlock()
+				mu.Lock()
+				holding[key]++
+				n := holding[key]
+				mu.Unlock()
+				if n != 1 {
+					t.Errorf("%d writes of %s at once", n, key)
+				}
+				mu.Lock()
+				holding[key]--
+				mu.Unlock()
+			}(key)
+		}
+	}
+	wg.Wait()
+
+	// another key isn't waiting for the one held
+	unlock := lockKey("vvtrip", "0001")
+	lockKey("vvtrip", "0002")()
+	lockKey("other", "0001")()
+	unlock()
+	keyLocks.Lock()
+	defer keyLocks.Unlock()
+	if len(keyLocks.byKey) != 0 {
+		t.Errorf("%d locks left after the writes", len(keyLocks.byKey))
+	}
+}
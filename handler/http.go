@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"goproxy4blockchain/utils"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+//the customers can't speak our tcp protocol, so the provenance is also served over http:
+// GET /provenance/{traceId}            html page, or json with ?format=json or "Accept: application/json"
+// GET /qrcode/{traceId}.png            qr code of the lookup url and the record digest, to be printed on the bag
+// GET /report/{traceId}?format=pdf     bilingual inspection report, format is text, html (default) or pdf
+// GET /labels                          zh-CN/en-US label catalogue of the record fields
+//给消费者扫码使用的http接口。
+
+var traceIDPattern = regexp.MustCompile(`^[0-9A-Za-z_-]{1,64}$`)
+
+//ProvenanceServer serves the provenance lookup of one channel.
+type ProvenanceServer struct {
+	Channel   string
+	PublicURL string
+	// ReportFont is a ttf font with chinese glyphs for the pdf reports.
+	ReportFont string
+	mux        *http.ServeMux
+}
+
+//NewProvenanceServer returns the http handler, publicURL is the address the customers can reach, e.g. https://trace.example.com
+func NewProvenanceServer(channel string, publicURL string) *ProvenanceServer {
+	ps := &ProvenanceServer{Channel: channel, PublicURL: strings.TrimRight(publicURL, "/")}
+	ps.mux = http.NewServeMux()
+	ps.mux.HandleFunc("/provenance/", ps.serveProvenance)
+	ps.mux.HandleFunc("/qrcode/", ps.serveQRCode)
+	ps.mux.HandleFunc("/report/", ps.serveReport)
+	ps.mux.HandleFunc("/labels", serveLabels)
+	return ps
+}
+
+func (ps *ProvenanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ps.mux.ServeHTTP(w, r)
+}
+
+//LookupURL is the url encoded in the qr code of a record.
+func (ps *ProvenanceServer) LookupURL(traceID string, digest string) string {
+	return ps.PublicURL + "/provenance/" + url.PathEscape(traceID) + "?digest=" + digest
+}
+
+func (ps *ProvenanceServer) serveProvenance(w http.ResponseWriter, r *http.Request) {
+	traceID := strings.TrimPrefix(r.URL.Path, "/provenance/")
+	if !traceIDPattern.MatchString(traceID) {
+		http.Error(w, "invalid trace id", http.StatusBadRequest)
+		return
+	}
+	prov, err := LookupProvenance(r.Context(), ps.Channel, traceID)
+	if err == ErrNotFound {
+		http.Error(w, "no record for "+traceID, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.Log("xxx serveProvenance()", traceID, "error:", err)
+		http.Error(w, "block chain service unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// the digest in the qr code tells whether the record was changed after the bag was printed
+	page := provenancePage{Provenance: prov, Verified: true}
+	if digest := r.URL.Query().Get("digest"); digest != "" {
+		page.Checked = true
+		page.Verified = digest == prov.Digest
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(page)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err = provenanceTemplate.Execute(w, page); err != nil {
+		utils.Log("xxx serveProvenance() render error:", err)
+	}
+}
+
+func (ps *ProvenanceServer) serveQRCode(w http.ResponseWriter, r *http.Request) {
+	traceID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/qrcode/"), ".png")
+	if !traceIDPattern.MatchString(traceID) {
+		http.Error(w, "invalid trace id", http.StatusBadRequest)
+		return
+	}
+	prov, err := LookupProvenance(r.Context(), ps.Channel, traceID)
+	if err == ErrNotFound {
+		http.Error(w, "no record for "+traceID, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.Log("xxx serveQRCode()", traceID, "error:", err)
+		http.Error(w, "block chain service unavailable", http.StatusBadGateway)
+		return
+	}
+	png, err := qrcode.Encode(ps.LookupURL(traceID, prov.Digest), qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+func (ps *ProvenanceServer) serveReport(w http.ResponseWriter, r *http.Request) {
+	traceID := strings.TrimPrefix(r.URL.Path, "/report/")
+	if !traceIDPattern.MatchString(traceID) {
+		http.Error(w, "invalid trace id", http.StatusBadRequest)
+		return
+	}
+	prov, err := LookupProvenance(r.Context(), ps.Channel, traceID)
+	if err == ErrNotFound {
+		http.Error(w, "no record for "+traceID, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.Log("xxx serveReport()", traceID, "error:", err)
+		http.Error(w, "block chain service unavailable", http.StatusBadGateway)
+		return
+	}
+	report, err := NewInspectionReport(traceID, prov.State, r.URL.Query().Get("kind"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+	default:
+		format = "html"
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	// render into a buffer first, a failed pdf must not be sent half written
+	var buf bytes.Buffer
+	if err = report.Render(&buf, format, ps.ReportFont); err != nil {
+		utils.Log("xxx serveReport() render error:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+func serveLabels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(labelCatalogue)
+}
+
+//provenancePage is what the json and html responses show.
+type provenancePage struct {
+	*Provenance
+	Checked  bool `json:"checked"`
+	Verified bool `json:"verified"`
+}
+
+//RecordJSON is the record indented for the html page.
+func (page provenancePage) RecordJSON() string {
+	if page.Record == nil {
+		return page.State
+	}
+	data, _ := json.MarshalIndent(page.Record, "", "  ")
+	return string(data)
+}
+
+//Time is to show the timestamp of a transaction.
+func (ts Timestamp) Time() string {
+	return time.Unix(int64(ts.Seconds), int64(ts.Nanos)).Format("2006-01-02 15:04:05")
+}
+
+var provenanceTemplate = template.Must(template.New("provenance").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>产品溯源 Provenance {{.TraceID}}</title>
+</head>
+<body>
+<h1>产品溯源 Provenance</h1>
+<p>追溯码 Trace ID: <b>{{.TraceID}}</b></p>
+{{if .Checked}}{{if .Verified}}<p style="color:green">✔ 记录与包装上的二维码一致 The record matches the code on the bag</p>{{else}}<p style="color:red">✘ 记录已在打印后更新 The record was changed after the code was printed</p>{{end}}{{end}}
+<h2>记录 Record</h2>
+<pre>{{.RecordJSON}}</pre>
+<p>摘要 Digest (sha256): <code>{{.Digest}}</code></p>
+<h2>上链记录 Transactions</h2>
+<table border="1" cellpadding="4">
+<tr><th>时间 Time</th><th>交易 Tx ID</th></tr>
+{{range .Transactions}}<tr><td>{{.Timestamp.Time}}</td><td><code>{{.Tx_id}}</code></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+/* Label catalogue of the record types in parser.go.
+   Every json field has a zh-CN and an en-US name for the reports; Aliases are the corrected spellings
+   ("quality" for "qulity", "sampleId" for "smapleId") accepted when reading, the records are still written with the old keys.
+
+   字段中英文名称；JSON中拼写错误的字段可用正确拼写写入，输出仍保持原字段名以兼容已上链的数据。
+*/
+
+//Label is the names of a json field.
+type Label struct {
+	JSON    string   `json:"json"`
+	ZH      string   `json:"zh-CN"`
+	EN      string   `json:"en-US"`
+	Aliases []string `json:"aliases,omitempty"`
+	// Type is the type of a nested object, or of the elements of a list.
+	Type string `json:"type,omitempty"`
+}
+
+//Name is the label in the language, "zh-CN" or "en-US".
+func (l Label) Name(lang string) string {
+	if strings.HasPrefix(lang, "en") {
+		return l.EN
+	}
+	return l.ZH
+}
+
+//labelCatalogue is the labels of each type, in the order of the fields.
+var labelCatalogue = map[string][]Label{
+	"QulityInfo": {
+		{JSON: "unpolishedRicePercentage", ZH: "糙米率", EN: "Brown rice rate", Aliases: []string{"unpolishedRiceRate"}},
+		{JSON: "polishedRiceRate", ZH: "精米率", EN: "Milled rice rate"},
+		{JSON: "headRiceRate", ZH: "整精米率", EN: "Head rice rate"},
+		{JSON: "grainLength", ZH: "粒长", EN: "Grain length"},
+		{JSON: "gelConsistency", ZH: "胶稠度", EN: "Gel consistency"},
+		{JSON: "tasteScore", ZH: "食味评分", EN: "Taste score"},
+	},
+	"SeedInfo": {
+		{JSON: "company", ZH: "生产企业", EN: "Producer"},
+		{JSON: "registeredNumber", ZH: "注册号", EN: "Registration number"},
+		{JSON: "unifiedSocialCreditCode", ZH: "统一社会信用代码", EN: "Unified social credit code"},
+		{JSON: "productInfo", ZH: "品种", EN: "Variety"},
+		{JSON: "seedValidationNumber", ZH: "种子审定编号", EN: "Seed approval number"},
+		{JSON: "qulity", ZH: "品质", EN: "Quality", Aliases: []string{"quality"}, Type: "QulityInfo"},
+	},
+	"BiologicalOrganicFertilizerInfo": {
+		{JSON: "company", ZH: "生产企业", EN: "Producer"},
+		{JSON: "unifiedSocialCreditCode", ZH: "统一社会信用代码", EN: "Unified social credit code"},
+		{JSON: "organizationCode", ZH: "组织机构代码", EN: "Organization code"},
+		{JSON: "registeredNumber", ZH: "注册号", EN: "Registration number"},
+		{JSON: "productInfo", ZH: "产品信息", EN: "Product"},
+		{JSON: "chemicalComposition", ZH: "成分", EN: "Composition", Type: "ChemicalCompositionInfo"},
+	},
+	"ChemicalCompositionInfo": {
+		{JSON: "enzyme", ZH: "酵素", EN: "Enzyme"},
+		{JSON: "organicMatter", ZH: "有机质", EN: "Organic matter"},
+		{JSON: "aminoAcid", ZH: "氨基酸", EN: "Amino acids"},
+		{JSON: "humicAcid", ZH: "腐殖酸", EN: "Humic acid"},
+	},
+	"OrganicAuthenticationInfo": {
+		{JSON: "organicEvidenceInBaseNum", ZH: "基地有机证编号", EN: "Organic farm certificate No."},
+		{JSON: "processingOrganicSyndromeNum", ZH: "加工有机证编号", EN: "Organic processing certificate No."},
+	},
+	"ProductInfomation": {
+		{JSON: "items", ZH: "检验项目", EN: "Inspection items", Type: "ProductItem"},
+	},
+	"ProductItem": {
+		{JSON: "id", ZH: "序号", EN: "No."},
+		{JSON: "inspectionProject", ZH: "检验项目", EN: "Inspection item"},
+		{JSON: "measurementUnit", ZH: "计量单位", EN: "Unit"},
+		{JSON: "standardRequirements", ZH: "标准要求", EN: "Standard requirement"},
+		{JSON: "measuredValue", ZH: "实测值", EN: "Measured value"},
+		{JSON: "singleConclusion", ZH: "单项结论", EN: "Conclusion"},
+	},
+	"SoilCheckReport": {
+		{JSON: "items", ZH: "检测项目", EN: "Test items", Type: "SoilCheckReportItem"},
+	},
+	"SoilCheckReportItem": {
+		{JSON: "smapleId", ZH: "样品编号", EN: "Sample ID", Aliases: []string{"sampleId"}},
+		{JSON: "sampleInspectionProject", ZH: "检测项目", EN: "Test item"},
+		{JSON: "soilUnit", ZH: "单位", EN: "Unit"},
+		{JSON: "soilIndex", ZH: "指标", EN: "Limit"},
+		{JSON: "soilMeasuredData", ZH: "实测值", EN: "Measured value"},
+		{JSON: "sampleNumDetectionLimit", ZH: "检出限", EN: "Detection limit"},
+		{JSON: "ln166872SingleConclusion", ZH: "单项结论", EN: "Conclusion"},
+		{JSON: "ln166872DetectionBasis", ZH: "检测依据", EN: "Test method"},
+	},
+}
+
+//RecordKinds is the record type of each kind, the kinds are the ones used by importer.
+var RecordKinds = map[string]string{
+	"seed":       "SeedInfo",
+	"fertilizer": "BiologicalOrganicFertilizerInfo",
+	"organic":    "OrganicAuthenticationInfo",
+	"product":    "ProductInfomation",
+	"soil":       "SoilCheckReport",
+}
+
+//kindTitles is the title of the report of each kind.
+var kindTitles = map[string]Label{
+	"seed":       {ZH: "种子信息", EN: "Seed information"},
+	"fertilizer": {ZH: "生物有机肥信息", EN: "Bio-organic fertilizer information"},
+	"organic":    {ZH: "有机认证信息", EN: "Organic certification"},
+	"product":    {ZH: "大米检验报告单", EN: "Rice inspection report"},
+	"soil":       {ZH: "土壤检测报告", EN: "Soil test report"},
+}
+
+//inspectionTerms is the english of the inspection items in the reports, e.g. 黄粒米, 垩白粒率.
+var inspectionTerms = map[string]string{
+	"感官要求":    "Sensory requirements",
+	"加工精度":    "Milling degree",
+	"黄粒米":     "Yellow grains",
+	"不完善粒":    "Imperfect grains",
+	"杂质总量":    "Total impurities",
+	"糠粉":      "Bran powder",
+	"矿物质":     "Mineral matter",
+	"碎米总量":    "Total broken rice",
+	"小碎米":     "Small broken rice",
+	"水份":      "Moisture",
+	"水分":      "Moisture",
+	"垩白粒率":    "Chalky grain rate",
+	"食味品质":    "Eating quality",
+	"直链淀粉":    "Amylose content",
+	"胶稠度":     "Gel consistency",
+	"霉变粒":     "Moldy grains",
+	"应符合标准要求": "Shall meet the standard",
+	"符合":      "Conforms",
+	"合格":      "Qualified",
+	"不合格":     "Unqualified",
+}
+
+//Labels is the labels of a type of parser.go, e.g. "SoilCheckReportItem".
+func Labels(typeName string) []Label {
+	return labelCatalogue[typeName]
+}
+
+//LabelOf is the label of a json field of a type, the aliases are looked up too.
+func LabelOf(typeName string, jsonKey string) (Label, bool) {
+	for _, l := range labelCatalogue[typeName] {
+		if l.JSON == jsonKey {
+			return l, true
+		}
+		for _, alias := range l.Aliases {
+			if alias == jsonKey {
+				return l, true
+			}
+		}
+	}
+	return Label{}, false
+}
+
+//TranslateTerm is the english of an inspection item or conclusion, "" if unknown.
+//The blanks used to align the printed reports ("胶 稠 度") are ignored.
+func TranslateTerm(term string) string {
+	return inspectionTerms[strings.NewReplacer(" ", "", "\u3000", "").Replace(term)]
+}
+
+//unmarshalAliased is to rename the alias keys of data to the json keys of the type before decoding it.
+func unmarshalAliased(data []byte, typeName string, v interface{}) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return json.Unmarshal(data, v)
+	}
+	renamed := false
+	for _, l := range labelCatalogue[typeName] {
+		for _, alias := range l.Aliases {
+			raw, ok := fields[alias]
+			if !ok {
+				continue
+			}
+			if _, exists := fields[l.JSON]; !exists {
+				fields[l.JSON] = raw
+			}
+			delete(fields, alias)
+			renamed = true
+		}
+	}
+	if renamed {
+		data, _ = json.Marshal(fields)
+	}
+	return json.Unmarshal(data, v)
+}
+
+//UnmarshalJSON accepts "unpolishedRiceRate" for "unpolishedRicePercentage".
+func (q *QulityInfo) UnmarshalJSON(data []byte) error {
+	type plain QulityInfo
+	return unmarshalAliased(data, "QulityInfo", (*plain)(q))
+}
+
+//UnmarshalJSON accepts "quality" for "qulity".
+func (s *SeedInfo) UnmarshalJSON(data []byte) error {
+	type plain SeedInfo
+	return unmarshalAliased(data, "SeedInfo", (*plain)(s))
+}
+
+//UnmarshalJSON accepts "sampleId" for "smapleId".
+func (item *SoilCheckReportItem) UnmarshalJSON(data []byte) error {
+	type plain SoilCheckReportItem
+	return unmarshalAliased(data, "SoilCheckReportItem", (*plain)(item))
+}
@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* The quality and composition fields used to be free text such as "84.1%", "≥8%", "6.6mm" or "88-92".
+   Measurement keeps the text for the json on the chain and exposes the number, unit, comparator and tolerance.
+   品质、成分字段的数值类型，json中仍然是原来的字符串，已上链的记录可以直接读取。
+*/
+
+//comparators of a measurement or a standard requirement.
+const (
+	CmpEqual        = "="
+	CmpGreaterEqual = "≥"
+	CmpLessEqual    = "≤"
+	CmpGreater      = ">"
+	CmpLess         = "<"
+	CmpRange        = "-"
+)
+
+//Measurement is a number with unit, e.g. "≥8%" is {Comparator: "≥", Value: 8, Unit: "%"} and "15-20" is {Comparator: "-", Value: 15, Upper: 20}.
+type Measurement struct {
+	Comparator string
+	Value      float64
+	Upper      float64
+	Tolerance  float64
+	Unit       string
+	// Text is the original text, written back unchanged to the json; clear it to build the text from the fields.
+	// A measurement that isn't a number (e.g. "应符合标准要求") keeps only the text, the zero Measurement is the empty text.
+	Text string
+}
+
+var measurementPattern = regexp.MustCompile(`^(≥|≤|>=|<=|>|<|=)?\s*(-?\d+(?:\.\d+)?)\s*(?:(?:-|~|～|—)\s*(\d+(?:\.\d+)?))?\s*(?:(?:±|\+/-)\s*(\d+(?:\.\d+)?))?\s*([^\d\s]*)$`)
+
+var comparatorAliases = map[string]string{
+	">=": CmpGreaterEqual,
+	"<=": CmpLessEqual,
+}
+
+var unitAliases = map[string]string{
+	"％":  "%",
+	"毫米": "mm",
+	"厘米": "cm",
+	"米":  "m",
+	"克":  "g",
+	"千克": "kg",
+	"公斤": "kg",
+	"分":  "points",
+}
+
+//unitScale is the factor to the base unit of each dimension, only units of the same base can be converted.
+var unitScale = map[string]struct {
+	base   string
+	factor float64
+}{
+	"mm":    {"m", 0.001},
+	"cm":    {"m", 0.01},
+	"m":     {"m", 1},
+	"%":     {"ratio", 0.01},
+	"‰":     {"ratio", 0.001},
+	"g":     {"kg", 0.001},
+	"kg":    {"kg", 1},
+	"mg/kg": {"mg/kg", 1},
+}
+
+//ParseMeasurement is to read a measurement from text, text which isn't a number is kept as it is.
+func ParseMeasurement(text string) Measurement {
+	m := Measurement{Text: text}
+	trimmed := strings.TrimSpace(text)
+	match := measurementPattern.FindStringSubmatch(trimmed)
+	if match == nil {
+		return m
+	}
+	m.Comparator = match[1]
+	if alias, ok := comparatorAliases[m.Comparator]; ok {
+		m.Comparator = alias
+	}
+	m.Value, _ = strconv.ParseFloat(match[2], 64)
+	if match[3] != "" {
+		m.Comparator = CmpRange
+		m.Upper, _ = strconv.ParseFloat(match[3], 64)
+	}
+	if match[4] != "" {
+		m.Tolerance, _ = strconv.ParseFloat(match[4], 64)
+	}
+	m.Unit = NormalizeUnit(match[5])
+	return m
+}
+
+//NormalizeUnit is to turn the chinese and full-width units into the short ones.
+func NormalizeUnit(unit string) string {
+	unit = strings.TrimSpace(unit)
+	if alias, ok := unitAliases[unit]; ok {
+		return alias
+	}
+	return strings.ToLower(unit)
+}
+
+//IsNumeric tells whether the measurement is a number: its text is one, or without text it was built from the fields.
+func (m Measurement) IsNumeric() bool {
+	if m.Text != "" {
+		return measurementPattern.MatchString(strings.TrimSpace(m.Text))
+	}
+	return m != Measurement{}
+}
+
+//Float is the number of the measurement, ok is false if it isn't one.
+func (m Measurement) Float() (value float64, ok bool) {
+	return m.Value, m.IsNumeric()
+}
+
+//Range is the lowest and highest value the measurement stands for, the tolerance included.
+func (m Measurement) Range() (low float64, high float64) {
+	low, high = m.Value-m.Tolerance, m.Value+m.Tolerance
+	if m.Comparator == CmpRange {
+		high = m.Upper + m.Tolerance
+	}
+	return low, high
+}
+
+//In is to convert the value to another unit of the same dimension, e.g. "6.6mm" in "cm" is 0.66.
+func (m Measurement) In(unit string) (float64, error) {
+	if !m.IsNumeric() {
+		return 0, fmt.Errorf("%q is not a number", m.Text)
+	}
+	unit = NormalizeUnit(unit)
+	if unit == m.Unit {
+		return m.Value, nil
+	}
+	from, ok1 := unitScale[m.Unit]
+	to, ok2 := unitScale[unit]
+	if !ok1 || !ok2 || from.base != to.base {
+		return 0, fmt.Errorf("can't convert %q to %s", m.Text, unit)
+	}
+	return m.Value * from.factor / to.factor, nil
+}
+
+//Satisfies tells whether value meets the measurement taken as a standard requirement, e.g. 0.05 satisfies "≤0.1";
+//nothing satisfies a requirement which isn't a number.
+func (m Measurement) Satisfies(value float64) bool {
+	if !m.IsNumeric() {
+		return false
+	}
+	low, high := m.Range()
+	switch m.Comparator {
+	case CmpGreaterEqual:
+		return value >= low
+	case CmpGreater:
+		return value > low
+	case CmpLessEqual:
+		return value <= high
+	case CmpLess:
+		return value < high
+	}
+	return value >= low && value <= high
+}
+
+//String is the text of the measurement, built from the fields when it was not parsed from text.
+func (m Measurement) String() string {
+	if m.Text != "" || !m.IsNumeric() {
+		return m.Text
+	}
+	text := m.Comparator + strconv.FormatFloat(m.Value, 'f', -1, 64)
+	if m.Comparator == CmpRange {
+		text = strconv.FormatFloat(m.Value, 'f', -1, 64) + "-" + strconv.FormatFloat(m.Upper, 'f', -1, 64)
+	} else if m.Comparator == CmpEqual {
+		text = text[len(CmpEqual):]
+	}
+	if m.Tolerance != 0 {
+		text += "±" + strconv.FormatFloat(m.Tolerance, 'f', -1, 64)
+	}
+	return text + m.Unit
+}
+
+//MarshalJSON writes the measurement as the string the records always had.
+func (m Measurement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+//UnmarshalJSON reads the string of the old records, a plain number is accepted too.
+func (m *Measurement) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = ParseMeasurement(text)
+		return nil
+	}
+	var value json.Number
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("measurement should be a string or a number: %s", string(data))
+	}
+	*m = ParseMeasurement(value.String())
+	return nil
+}
+
+//Conforms tells whether the measured value meets the standard requirement, checked is false if any of them isn't a number.
+func (item ProductItem) Conforms() (ok bool, checked bool) {
+	return conforms(item.StandardRequirements, item.MeasuredValue)
+}
+
+//Conforms tells whether the measured data meets the soil index, checked is false if any of them isn't a number.
+func (item SoilCheckReportItem) Conforms() (ok bool, checked bool) {
+	return conforms(item.SoilIndex, item.SoilMeasuredData)
+}
+
+func conforms(requirement string, measured string) (ok bool, checked bool) {
+	req := ParseMeasurement(requirement)
+	value, isNumber := ParseMeasurement(measured).Float()
+	if !req.IsNumeric() || !isNumber {
+		return false, false
+	}
+	return req.Satisfies(value), true
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMeasurementNumeric(t *testing.T) {
+	tests := []struct {
+		name      string
+		m         Measurement
+		numeric   bool
+		text      string
+		satisfied float64
+	}{
+		{name: "parsed", m: ParseMeasurement("≥8%"), numeric: true, text: "≥8%", satisfied: 9},
+		{name: "parsed range", m: ParseMeasurement("88-92"), numeric: true, text: "88-92", satisfied: 90},
+		{name: "text only", m: ParseMeasurement("应符合标准要求"), text: "应符合标准要求"},
+		{name: "empty", m: ParseMeasurement(""), text: ""},
+		{name: "built", m: Measurement{Comparator: CmpLessEqual, Value: 0.1, Unit: "mg/kg"}, numeric: true, text: "≤0.1mg/kg", satisfied: 0.05},
+		{name: "built zero", m: Measurement{Comparator: CmpEqual}, numeric: true, text: "0"},
+		{name: "text set by hand", m: Measurement{Text: "6.6mm"}, numeric: true, text: "6.6mm"},
+		{name: "text and fields", m: Measurement{Text: "合格", Value: 1}, text: "合格"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.IsNumeric(); got != tt.numeric {
+				t.Errorf("IsNumeric() = %v, want %v", got, tt.numeric)
+			}
+			if _, ok := tt.m.Float(); ok != tt.numeric {
+				t.Errorf("Float() ok = %v, want %v", ok, tt.numeric)
+			}
+			if got := tt.m.String(); got != tt.text {
+				t.Errorf("String() = %q, want %q", got, tt.text)
+			}
+			if tt.numeric && !tt.m.Satisfies(tt.satisfied) {
+				t.Errorf("%v doesn't satisfy %q", tt.satisfied, tt.text)
+			}
+			if !tt.numeric && (tt.m.Satisfies(0) || tt.m.Satisfies(1)) {
+				t.Errorf("%q is satisfied", tt.text)
+			}
+			data, err := json.Marshal(tt.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var back Measurement
+			if err := json.Unmarshal(data, &back); err != nil {
+				t.Fatal(err)
+			}
+			if back.String() != tt.text || back.IsNumeric() != tt.numeric {
+				t.Errorf("json %s reads back as %q, numeric %v", data, back.String(), back.IsNumeric())
+			}
+		})
+	}
+}
@@ -10,12 +10,12 @@ package handler
 品质：经品质分析糙米率84.1%，精米率75.7%，整精米率66.8%，粒长6.6mm,胶稠度67.0%，食味评分88-92。
 */
 type QulityInfo struct {
-	UnpolishedRiceRate string `json:"unpolishedRicePercentage"`
-	PolishedRiceRate   string `json:"polishedRiceRate"`
-	HeadRiceRate       string `json:"headRiceRate"`
-	GrainLength        string `json:"grainLength"`
-	GelConsistency     string `json:"gelConsistency"`
-	TasteScore         string `json:"tasteScore"`
+	UnpolishedRiceRate Measurement `json:"unpolishedRicePercentage"`
+	PolishedRiceRate   Measurement `json:"polishedRiceRate"`
+	HeadRiceRate       Measurement `json:"headRiceRate"`
+	GrainLength        Measurement `json:"grainLength"`
+	GelConsistency     Measurement `json:"gelConsistency"`
+	TasteScore         Measurement `json:"tasteScore"`
 }
 
 type SeedInfo struct {
@@ -46,10 +46,10 @@ type BiologicalOrganicFertilizerInfo struct {
 }
 
 type ChemicalCompositionInfo struct {
-	Enzyme        string `json:"enzyme"`
-	OrganicMatter string `json:"organicMatter"`
-	AminoAcid     string `json:"aminoAcid"`
-	HumicAcid     string `json:"humicAcid"`
+	Enzyme        Measurement `json:"enzyme"`
+	OrganicMatter Measurement `json:"organicMatter"`
+	AminoAcid     Measurement `json:"aminoAcid"`
+	HumicAcid     Measurement `json:"humicAcid"`
 }
 
 /*
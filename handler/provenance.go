@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goproxy4blockchain/jsonrpc"
+	"goproxy4blockchain/utils"
+)
+
+//the provenance of a product is resolved from the same source-state and source-transactions calls the controllers use;
+//the trace id printed on the rice bag is the key of the record on the chain.
+//溯源查询：包装上的追溯码即链上记录的key，通过source-state取当前记录，source-transactions取历史交易。
+
+//ErrNotFound is returned when nothing is stored under the trace id.
+var ErrNotFound = errors.New("record not found")
+
+//Provenance is the resolved trace of a product.
+type Provenance struct {
+	Channel      string              `json:"channel"`
+	TraceID      string              `json:"traceId"`
+	State        string              `json:"state"`
+	Version      int                 `json:"version"`
+	Record       interface{}         `json:"record,omitempty"`
+	Digest       string              `json:"digest"`
+	Transactions []ResultTransaction `json:"transactions"`
+}
+
+//RecordDigest is the sha256 of the stored value, printed in the qr code so a customer can tell whether the record changed.
+func RecordDigest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+//LookupProvenance is to resolve the current record and the transactions of a trace id.
+func LookupProvenance(ctx context.Context, channel string, traceID string) (*Provenance, error) {
+	params := &MethodParams{Channel: channel, Key: traceID}
+
+	rpcResp, err := callUpstream(ctx, StateMethod, params)
+	if err != nil {
+		return nil, err
+	}
+	var state ResultState
+	if err = rpcResp.GetObject(&state); err != nil {
+		return nil, err
+	}
+	if state.State == "" {
+		return nil, ErrNotFound
+	}
+
+	rpcResp, err = callUpstream(ctx, TransactionsMethod, params)
+	if err != nil {
+		return nil, err
+	}
+	var transactions []ResultTransaction
+	if err = rpcResp.GetObject(&transactions); err != nil {
+		return nil, err
+	}
+
+	prov := &Provenance{
+		Channel:      channel,
+		TraceID:      traceID,
+		State:        state.State,
+		Digest:       RecordDigest(state.State),
+		Transactions: transactions,
+	}
+	// most records are versioned json documents of the types in parser.go, others are shown as they are
+	amendment, raw := UnwrapRecord(state.State)
+	prov.Version = amendment.Version
+	var record interface{}
+	if json.Unmarshal(raw, &record) == nil {
+		prov.Record = record
+	}
+	return prov, nil
+}
+
+//callUpstream is to send one request to the chain and turn a JSON-RPC error into an error.
+func callUpstream(ctx context.Context, method string, params *MethodParams) (*jsonrpc.RPCResponse, error) {
+	rpcResp, err := sendJsonrpcRequest(ctx, method, params)
+	if err != nil {
+		return nil, err
+	}
+	if rpcResp == nil {
+		return nil, fmt.Errorf("%s: no response from block chain service", method)
+	}
+	if rpcResp.Error != nil {
+		utils.Log("xxx callUpstream()", method, "error:", rpcResp.Error.Error())
+		return nil, rpcResp.Error
+	}
+	return rpcResp, nil
+}
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"fmt"
+	"goproxy4blockchain/utils"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Quotas of the requests sent to the block chain service, in the limits section of config.yaml:
+   limits:
+     global:    {rate: 50, burst: 100, inflight: 32}   # all the clients together
+     principal: {rate: 5, burst: 10, inflight: 4}      # each client
+     channel:   {rate: 20, burst: 40, inflight: 16}    # each channel
+     principals:                                       # a client with its own quota
+       importer: {rate: 20, burst: 200, inflight: 8}
+     channels:
+       vvtrip: {rate: 30, burst: 60}
+   rate is the requests per second, burst the size of the bucket, inflight the requests waiting for the chain;
+   0 or omitted means no limit.
+   一个应用占满ninechain的API配额会影响所有人，按客户端、channel和全局做令牌桶限流和并发限制。
+*/
+
+//ErrCodeRateLimited is returned when a quota is exceeded, the data carries the retry-after hint.
+const ErrCodeRateLimited = -32002
+
+//inflightRetryAfter is the hint given when too many requests are in flight, there is no bucket to tell when one will finish.
+const inflightRetryAfter = time.Second
+
+//bucketIdleTimeout is how long an idle client or channel keeps its bucket.
+const bucketIdleTimeout = 10 * time.Minute
+
+//LimitConfig is the quota of one scope.
+type LimitConfig struct {
+	Rate     float64 `json:"rate,omitempty"`
+	Burst    int     `json:"burst,omitempty"`
+	Inflight int     `json:"inflight,omitempty"`
+}
+
+func (c LimitConfig) unlimited() bool {
+	return c.Rate <= 0 && c.Inflight <= 0
+}
+
+//bucket is the token bucket and in-flight counter of one client, channel or the whole proxy.
+type bucket struct {
+	scope    string
+	name     string
+	config   LimitConfig
+	tokens   float64
+	last     time.Time
+	used     time.Time
+	inflight int
+	rejected uint64
+}
+
+func newBucket(scope string, name string, config LimitConfig, now time.Time) *bucket {
+	if config.Rate > 0 && config.Burst <= 0 {
+		config.Burst = int(math.Ceil(config.Rate))
+	}
+	return &bucket{scope: scope, name: name, config: config, tokens: float64(config.Burst), last: now, used: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.config.Rate <= 0 {
+		return
+	}
+	b.tokens = math.Min(float64(b.config.Burst), b.tokens+now.Sub(b.last).Seconds()*b.config.Rate)
+	b.last = now
+}
+
+//wait is how long the request has to wait for this bucket, 0 if it may go now.
+func (b *bucket) wait() time.Duration {
+	if b.config.Inflight > 0 && b.inflight >= b.config.Inflight {
+		return inflightRetryAfter
+	}
+	if b.config.Rate > 0 && b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / b.config.Rate * float64(time.Second))
+	}
+	return 0
+}
+
+func (b *bucket) idle(now time.Time) bool {
+	return b.inflight == 0 && now.Sub(b.used) > bucketIdleTimeout
+}
+
+//Limiter applies the global, per client and per channel quotas.
+type Limiter struct {
+	Global     LimitConfig
+	Principal  LimitConfig
+	Channel    LimitConfig
+	Principals map[string]LimitConfig
+	Channels   map[string]LimitConfig
+
+	mu         sync.Mutex
+	global     *bucket
+	principals map[string]*bucket
+	channels   map[string]*bucket
+	lastPrune  time.Time
+}
+
+//NewLimiter is to read the limits section of the config, nil section means no limits.
+func NewLimiter(section map[interface{}]interface{}) (*Limiter, error) {
+	if section == nil {
+		return nil, nil
+	}
+	limiter := &Limiter{
+		Principals: make(map[string]LimitConfig),
+		Channels:   make(map[string]LimitConfig),
+		principals: make(map[string]*bucket),
+		channels:   make(map[string]*bucket),
+	}
+	var err error
+	if limiter.Global, err = limitConfig("global", section["global"]); err != nil {
+		return nil, err
+	}
+	if limiter.Principal, err = limitConfig("principal", section["principal"]); err != nil {
+		return nil, err
+	}
+	if limiter.Channel, err = limitConfig("channel", section["channel"]); err != nil {
+		return nil, err
+	}
+	for name, overrides := range map[string]map[string]LimitConfig{"principals": limiter.Principals, "channels": limiter.Channels} {
+		for id, value := range utils.GetSection(name, section) {
+			if overrides[fmt.Sprint(id)], err = limitConfig(name+" "+fmt.Sprint(id), value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	limiter.global = newBucket("global", "", limiter.Global, time.Now())
+	return limiter, nil
+}
+
+func limitConfig(name string, value interface{}) (LimitConfig, error) {
+	var config LimitConfig
+	if value == nil {
+		return config, nil
+	}
+	entry, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return config, fmt.Errorf("limits %s: expect rate, burst and inflight", name)
+	}
+	for key, v := range entry {
+		number, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+		if err != nil || number < 0 {
+			return config, fmt.Errorf("limits %s: %v must be a positive number", name, key)
+		}
+		switch key {
+		case "rate":
+			config.Rate = number
+		case "burst":
+			config.Burst = int(number)
+		case "inflight":
+			config.Inflight = int(number)
+		default:
+			return config, fmt.Errorf("limits %s: unknown key %v, use rate, burst and inflight", name, key)
+		}
+	}
+	return config, nil
+}
+
+//bucketsOf is the buckets a request of principal on channel counts against, the caller holds mu.
+func (limiter *Limiter) bucketsOf(principal string, channel string, now time.Time) []*bucket {
+	buckets := []*bucket{limiter.global}
+	get := func(scope string, name string, all map[string]*bucket, overrides map[string]LimitConfig, config LimitConfig) {
+		b, ok := all[name]
+		if !ok {
+			if override, ok := overrides[name]; ok {
+				config = override
+			}
+			if config.unlimited() {
+				return
+			}
+			b = newBucket(scope, name, config, now)
+			all[name] = b
+		}
+		buckets = append(buckets, b)
+	}
+	get("principal", principal, limiter.principals, limiter.Principals, limiter.Principal)
+	get("channel", channel, limiter.channels, limiter.Channels, limiter.Channel)
+	return buckets
+}
+
+//Acquire is to take a token and an in-flight slot for a request, release must be called when the request is done.
+//When a quota is exceeded, nothing is taken and retryAfter is when to try again.
+func (limiter *Limiter) Acquire(principal string, channel string) (release func(), retryAfter time.Duration, scope string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	now := time.Now()
+	limiter.prune(now)
+	buckets := limiter.bucketsOf(principal, channel, now)
+	for _, b := range buckets {
+		b.refill(now)
+		if wait := b.wait(); wait > 0 {
+			b.rejected++
+			return nil, wait, b.scope + " " + b.name
+		}
+	}
+	for _, b := range buckets {
+		if b.config.Rate > 0 {
+			b.tokens--
+		}
+		b.inflight++
+		b.used = now
+	}
+	return func() {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		for _, b := range buckets {
+			b.inflight--
+		}
+	}, 0, ""
+}
+
+//prune is to drop the buckets of the clients and channels idle for a while, the caller holds mu.
+func (limiter *Limiter) prune(now time.Time) {
+	if now.Sub(limiter.lastPrune) < time.Minute {
+		return
+	}
+	limiter.lastPrune = now
+	for _, all := range []map[string]*bucket{limiter.principals, limiter.channels} {
+		for name, b := range all {
+			if b.idle(now) {
+				delete(all, name)
+			}
+		}
+	}
+}
+
+//LimitUsage is the current usage of one quota.
+type LimitUsage struct {
+	Scope    string      `json:"scope"`
+	Name     string      `json:"name,omitempty"`
+	Limit    LimitConfig `json:"limit"`
+	Tokens   float64     `json:"tokens"`
+	Inflight int         `json:"inflight"`
+	Rejected uint64      `json:"rejected"`
+}
+
+//Usage is the current usage of all the quotas, for the admin interface.
+func (limiter *Limiter) Usage() []LimitUsage {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	now := time.Now()
+	usage := []LimitUsage{}
+	add := func(b *bucket) {
+		b.refill(now)
+		usage = append(usage, LimitUsage{Scope: b.scope, Name: b.name, Limit: b.config,
+			Tokens: math.Floor(b.tokens*100) / 100, Inflight: b.inflight, Rejected: b.rejected})
+	}
+	if !limiter.Global.unlimited() {
+		add(limiter.global)
+	}
+	for _, all := range []map[string]*bucket{limiter.principals, limiter.channels} {
+		names := make([]string, 0, len(all))
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(all[name])
+		}
+	}
+	return usage
+}
+
+var currentLimiter atomic.Value
+
+func init() {
+	currentLimiter.Store((*Limiter)(nil))
+}
+
+//UseLimiter is to apply the quotas of limiter from now on, nil means no limits; the config reload swaps it.
+//The requests in flight release their quota on the limiter they acquired it from.
+func UseLimiter(limiter *Limiter) {
+	currentLimiter.Store(limiter)
+}
+
+//CurrentLimiter is the limiter in use, nil when there are no limits.
+func CurrentLimiter() *Limiter {
+	return currentLimiter.Load().(*Limiter)
+}
+
+//LimiterMiddleware is the Middleware of the limiter in use, so new limits apply to the next request.
+func LimiterMiddleware(next Controller) Controller {
+	return ControllerFunc(func(message Msg) []byte {
+		limiter := CurrentLimiter()
+		if limiter == nil {
+			return next.Excute(message)
+		}
+		return limiter.Middleware(next).Excute(message)
+	})
+}
+
+//Middleware is to reject the requests over quota before they are sent to the block chain service.
+func (limiter *Limiter) Middleware(next Controller) Controller {
+	return ControllerFunc(func(message Msg) []byte {
+		rpcRequest := message.Content
+		principal := AnonymousPrincipal
+		if p := message.Principal(); p != nil {
+			principal = p.ID
+		}
+		params := requestParams(rpcRequest.Params)
+		release, retryAfter, scope := limiter.Acquire(principal, params.Channel)
+		if release == nil {
+			utils.LoggerFrom(message.Context()).Warn("xxx rate limited by", scope, "retry after", retryAfter)
+			return errorResponse(rpcRequest.ID, ErrCodeRateLimited, "rate limited", map[string]interface{}{
+				"retryAfterMs": int64(math.Ceil(float64(retryAfter) / float64(time.Millisecond))),
+				"limit":        scope,
+			})
+		}
+		defer release()
+		return next.Excute(message)
+	})
+}
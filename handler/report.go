@@ -0,0 +1,324 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+//InspectionReport is a stored record laid out with the zh-CN and en-US labels of the catalogue.
+type InspectionReport struct {
+	Kind     string
+	Title    Label
+	TraceID  string
+	Version  int
+	Fields   []ReportField
+	Sections []ReportSection
+}
+
+//ReportField is one labelled value.
+type ReportField struct {
+	Label Label
+	Value string
+}
+
+//ReportSection is a nested object (Fields) or a list of items (Columns and Rows).
+type ReportSection struct {
+	Title   Label
+	Fields  []ReportField
+	Columns []Label
+	Rows    [][]string
+}
+
+//recordTypes is to decode a record into its type, so the aliases are accepted and the keys are the canonical ones.
+var recordTypes = map[string]func() interface{}{
+	"SeedInfo":                        func() interface{} { return new(SeedInfo) },
+	"BiologicalOrganicFertilizerInfo": func() interface{} { return new(BiologicalOrganicFertilizerInfo) },
+	"OrganicAuthenticationInfo":       func() interface{} { return new(OrganicAuthenticationInfo) },
+	"ProductInfomation":               func() interface{} { return new(ProductInfomation) },
+	"SoilCheckReport":                 func() interface{} { return new(SoilCheckReport) },
+}
+
+//DetectRecordKind is to guess the kind of a record from its keys, "" if it is none of parser.go.
+func DetectRecordKind(record json.RawMessage) string {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(record, &fields) != nil {
+		return ""
+	}
+	has := func(m map[string]json.RawMessage, keys ...string) bool {
+		for _, k := range keys {
+			if _, ok := m[k]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has(fields, "seedValidationNumber", "qulity", "quality"):
+		return "seed"
+	case has(fields, "chemicalComposition"):
+		return "fertilizer"
+	case has(fields, "organicEvidenceInBaseNum", "processingOrganicSyndromeNum"):
+		return "organic"
+	case has(fields, "items"):
+		var items []map[string]json.RawMessage
+		json.Unmarshal(fields["items"], &items)
+		for _, item := range items {
+			if has(item, "smapleId", "sampleId", "sampleInspectionProject", "soilIndex") {
+				return "soil"
+			}
+			if has(item, "inspectionProject", "measuredValue", "standardRequirements") {
+				return "product"
+			}
+		}
+	}
+	return ""
+}
+
+//NewInspectionReport is to lay out a stored value, kind is detected when it is "".
+func NewInspectionReport(traceID string, value string, kind string) (*InspectionReport, error) {
+	amendment, raw := UnwrapRecord(value)
+	if kind == "" {
+		kind = DetectRecordKind(raw)
+	}
+	typeName, ok := RecordKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown record kind %q", kind)
+	}
+	record := recordTypes[typeName]()
+	if err := json.Unmarshal(raw, record); err != nil {
+		return nil, err
+	}
+	canonical, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(canonical, &fields); err != nil {
+		return nil, err
+	}
+
+	report := &InspectionReport{Kind: kind, Title: kindTitles[kind], TraceID: traceID, Version: amendment.Version}
+	for _, l := range Labels(typeName) {
+		switch v := fields[l.JSON].(type) {
+		case map[string]interface{}:
+			section := ReportSection{Title: l}
+			for _, nl := range Labels(l.Type) {
+				section.Fields = append(section.Fields, ReportField{Label: nl, Value: cellText(nl, v[nl.JSON])})
+			}
+			report.Sections = append(report.Sections, section)
+		case []interface{}:
+			section := ReportSection{Title: l, Columns: Labels(l.Type)}
+			for _, item := range v {
+				obj, _ := item.(map[string]interface{})
+				row := make([]string, len(section.Columns))
+				for i, cl := range section.Columns {
+					row[i] = cellText(cl, obj[cl.JSON])
+				}
+				section.Rows = append(section.Rows, row)
+			}
+			report.Sections = append(report.Sections, section)
+		default:
+			report.Fields = append(report.Fields, ReportField{Label: l, Value: cellText(l, v)})
+		}
+	}
+	return report, nil
+}
+
+//cellText is the text of a value, the inspection items and conclusions are given in both languages.
+func cellText(l Label, v interface{}) string {
+	var text string
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		text = value
+	case float64:
+		text = strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		text = showJSON(value)
+	}
+	if en := TranslateTerm(text); en != "" {
+		return text + " " + en
+	}
+	return text
+}
+
+//Bilingual is the zh-CN and en-US names together, e.g. "黄粒米 Yellow grains".
+func (l Label) Bilingual() string {
+	return l.ZH + " " + l.EN
+}
+
+//Render is to write the report as "text", "html" or "pdf"; fontPath is a ttf font with chinese glyphs, needed by pdf.
+func (report *InspectionReport) Render(w io.Writer, format string, fontPath string) error {
+	switch format {
+	case "", "text":
+		return report.renderText(w)
+	case "html":
+		return reportTemplate.Execute(w, report)
+	case "pdf":
+		return report.renderPDF(w, fontPath)
+	}
+	return fmt.Errorf("unknown report format %q, use text, html or pdf", format)
+}
+
+func (report *InspectionReport) renderText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\n", report.Title.Bilingual())
+	fmt.Fprintf(tw, "追溯码 Trace ID:\t%s\n", report.TraceID)
+	fmt.Fprintf(tw, "版本 Version:\t%d\n", report.Version)
+	for _, f := range report.Fields {
+		fmt.Fprintf(tw, "%s:\t%s\n", f.Label.Bilingual(), f.Value)
+	}
+	for _, s := range report.Sections {
+		fmt.Fprintf(tw, "\n%s\n", s.Title.Bilingual())
+		for _, f := range s.Fields {
+			fmt.Fprintf(tw, "%s:\t%s\n", f.Label.Bilingual(), f.Value)
+		}
+		if len(s.Columns) == 0 {
+			continue
+		}
+		zh := make([]string, len(s.Columns))
+		en := make([]string, len(s.Columns))
+		for i, c := range s.Columns {
+			zh[i], en[i] = c.ZH, c.EN
+		}
+		fmt.Fprintln(tw, strings.Join(zh, "\t"))
+		fmt.Fprintln(tw, strings.Join(en, "\t"))
+		for _, row := range s.Rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+	}
+	return tw.Flush()
+}
+
+func (report *InspectionReport) renderPDF(w io.Writer, fontPath string) error {
+	if fontPath == "" {
+		return fmt.Errorf("pdf report needs a ttf font with chinese glyphs, set reportfont in config.yaml")
+	}
+	pdf := gofpdf.New("L", "mm", "A4", filepath.Dir(fontPath))
+	pdf.AddUTF8Font("cjk", "", filepath.Base(fontPath))
+	pdf.SetFont("cjk", "", 10)
+	pdf.AddPage()
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	width := pageWidth - left - right
+	const lineHeight = 6.0
+
+	pdf.SetFontSize(16)
+	pdf.CellFormat(width, 10, report.Title.Bilingual(), "", 1, "C", false, 0, "")
+	pdf.SetFontSize(10)
+	fields := append([]ReportField{
+		{Label: Label{ZH: "追溯码", EN: "Trace ID"}, Value: report.TraceID},
+		{Label: Label{ZH: "版本", EN: "Version"}, Value: strconv.Itoa(report.Version)},
+	}, report.Fields...)
+	writeFields := func(fields []ReportField) {
+		for _, f := range fields {
+			pdf.CellFormat(width*0.4, lineHeight, f.Label.Bilingual(), "", 0, "L", false, 0, "")
+			pdf.MultiCell(width*0.6, lineHeight, f.Value, "", "L", false)
+		}
+	}
+	writeFields(fields)
+
+	for _, s := range report.Sections {
+		pdf.Ln(4)
+		pdf.SetFontSize(12)
+		pdf.CellFormat(width, 8, s.Title.Bilingual(), "", 1, "L", false, 0, "")
+		pdf.SetFontSize(10)
+		writeFields(s.Fields)
+		if len(s.Columns) == 0 {
+			continue
+		}
+		colWidth := width / float64(len(s.Columns))
+		header := make([]string, len(s.Columns))
+		for i, c := range s.Columns {
+			header[i] = c.ZH + "\n" + c.EN
+		}
+		pdfRow(pdf, header, colWidth, lineHeight, true)
+		for _, row := range s.Rows {
+			pdfRow(pdf, row, colWidth, lineHeight, false)
+		}
+	}
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+//pdfRow is to draw one table row, the cells are wrapped and the row is as high as its highest cell.
+func pdfRow(pdf *gofpdf.Fpdf, cells []string, colWidth float64, lineHeight float64, fill bool) {
+	lines := 1
+	for _, cell := range cells {
+		if n := len(wrapText(pdf, cell, colWidth-2)); n > lines {
+			lines = n
+		}
+	}
+	height := float64(lines) * lineHeight
+	_, pageHeight := pdf.GetPageSize()
+	_, _, _, bottom := pdf.GetMargins()
+	if pdf.GetY()+height > pageHeight-bottom {
+		pdf.AddPage()
+	}
+	x, y := pdf.GetXY()
+	style := "D"
+	if fill {
+		pdf.SetFillColor(230, 230, 230)
+		style = "FD"
+	}
+	for i, cell := range cells {
+		pdf.Rect(x+float64(i)*colWidth, y, colWidth, height, style)
+		pdf.SetXY(x+float64(i)*colWidth, y)
+		pdf.MultiCell(colWidth, lineHeight, cell, "", "L", false)
+	}
+	pdf.SetXY(x, y+height)
+}
+
+//wrapText is to split text into lines fitting width; SplitText of gofpdf doesn't work with utf-8 fonts.
+func wrapText(pdf *gofpdf.Fpdf, text string, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, r := range paragraph {
+			if line != "" && pdf.GetStringWidth(line+string(r)) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			line += string(r)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title.ZH}} {{.Title.EN}} {{.TraceID}}</title>
+<style>table{border-collapse:collapse}td,th{border:1px solid #999;padding:4px}th small{display:block;font-weight:normal}</style>
+</head>
+<body>
+<h1>{{.Title.ZH}}<br><small>{{.Title.EN}}</small></h1>
+<table>
+<tr><th>追溯码 <small>Trace ID</small></th><td>{{.TraceID}}</td></tr>
+<tr><th>版本 <small>Version</small></th><td>{{.Version}}</td></tr>
+{{range .Fields}}<tr><th>{{.Label.ZH}} <small>{{.Label.EN}}</small></th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{range .Sections}}<h2>{{.Title.ZH}} <small>{{.Title.EN}}</small></h2>
+{{if .Fields}}<table>
+{{range .Fields}}<tr><th>{{.Label.ZH}} <small>{{.Label.EN}}</small></th><td>{{.Value}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Columns}}<table>
+<tr>{{range .Columns}}<th>{{.ZH}} <small>{{.EN}}</small></th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+{{end}}</body>
+</html>
+`))
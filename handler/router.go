@@ -1,11 +1,23 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"goproxy4blockchain/jsonrpc"
 	"goproxy4blockchain/utils"
 	"net"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //in this part, we try to decouple the whole code by a route-controller structure;
@@ -88,12 +100,50 @@ type RPCError struct {
 type MethodParams struct {
 	Channel string `json:"channel"`
 	Key     string `json:"key"`
+	Value   string `json:"value,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Version int    `json:"version,omitempty"`
+	// Kind is the record kind of a write, one of RecordKinds; it is detected from the fields when it is empty.
+	Kind string `json:"kind,omitempty"`
+	// Signature and KeyID are set by the proxy on the writes, see signing.go.
+	Signature string `json:"signature,omitempty"`
+	KeyID     string `json:"keyId,omitempty"`
+}
+
+//WriteMethod is the chain method used to store a record under a key.
+const WriteMethod = "source-save"
+
+//the chain methods used to read the record of a key and its transactions.
+const (
+	StateMethod        = "source-state"
+	TransactionsMethod = "source-transactions"
+)
+
+//JSON-RPC error codes returned by the proxy itself.
+const (
+	ErrCodeInternal        = -32603
+	ErrCodeForbidden       = -32001
+	ErrCodeGoingAway       = -32003
+	ErrCodeVersionConflict = -32010
+	ErrCodeRecordKind      = -32011
+)
+
+//errorResponse is to build the JSON-RPC error returned to app client.
+func errorResponse(id uint, code int, message string, data interface{}) []byte {
+	respMsg, err := json.Marshal(&jsonrpc.RPCResponse{
+		JSONRPC: "2.0",
+		Error:   &jsonrpc.RPCError{Code: code, Message: message, Data: data},
+		ID:      id,
+	})
+	utils.CheckError(err)
+	return respMsg
 }
 
 //Msg defined between app client and goproxy4blockchain
 type Msg struct {
 	Meta    map[string]interface{} `json:"meta"`
 	Content jsonrpc.RPCRequest     `json:"content"`
+	ctx     context.Context
 }
 
 /*
@@ -108,8 +158,68 @@ type Controller interface {
 	Excute(message Msg) []byte
 }
 
+//ControllerFunc is to use an ordinary function as a Controller.
+type ControllerFunc func(message Msg) []byte
+
+//Excute calls f(message).
+func (f ControllerFunc) Excute(message Msg) []byte {
+	return f(message)
+}
+
+//Middleware wraps a controller, to check or count the messages before they reach it and the block chain service.
+type Middleware func(next Controller) Controller
+
 var routers [][2]interface{}
 
+//routeRules describes the predicates of routers, for the route table of the admin interface.
+var routeRules []string
+
+var middlewares []Middleware
+
+//Use is to put a middleware in front of all the controllers, the first one used runs first.
+func Use(mw Middleware) {
+	middlewares = append(middlewares, mw)
+}
+
+//withMiddlewares is to wrap controller with the middlewares in use.
+func withMiddlewares(controller Controller) Controller {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		controller = middlewares[i](controller)
+	}
+	return controller
+}
+
+//routeName is the type of the controller of a route, the route label of the metrics.
+func routeName(controller interface{}) string {
+	name := fmt.Sprintf("%T", controller)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+//funcName is the name of a function without the module path, for the route table.
+func funcName(f interface{}) string {
+	name := strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name(), "-fm")
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+//RouteInfo is a route of the route table.
+type RouteInfo struct {
+	Rule       string `json:"rule"`
+	Controller string `json:"controller"`
+}
+
+//RouteTable is the routes in the order they are tried, and the middlewares in the order they run.
+func RouteTable() (routes []RouteInfo, middlewareNames []string) {
+	routes = make([]RouteInfo, 0, len(routers))
+	for i, r := range routers {
+		routes = append(routes, RouteInfo{Rule: routeRules[i], Controller: routeName(r[1])})
+	}
+	middlewareNames = make([]string, 0, len(middlewares))
+	for _, mw := range middlewares {
+		middlewareNames = append(middlewareNames, funcName(mw))
+	}
+	return routes, middlewareNames
+}
+
 //Route is to add the pred and controller pair into routers;
 func Route(pred interface{}, controller Controller) {
 	switch pred.(type) {
@@ -119,6 +229,7 @@ func Route(pred interface{}, controller Controller) {
 			arr[0] = pred
 			arr[1] = controller
 			routers = append(routers, arr)
+			routeRules = append(routeRules, funcName(pred))
 		}
 	case map[string]interface{}:
 		{
@@ -138,6 +249,7 @@ func Route(pred interface{}, controller Controller) {
 			arr[0] = defaultPred
 			arr[1] = controller
 			routers = append(routers, arr)
+			routeRules = append(routeRules, fmt.Sprint(pred))
 			fmt.Println(routers)
 		}
 	default:
@@ -145,51 +257,170 @@ func Route(pred interface{}, controller Controller) {
 	}
 }
 
-//TaskDeliver is to handle the message from app client
-func TaskDeliver(postdata []byte, conn net.Conn) {
+//TaskDeliver is to handle the message from app client, ctx carries the peer and the principal of the connection.
+func TaskDeliver(ctx context.Context, postdata []byte, conn net.Conn) {
+	start := time.Now()
+	reqid := utils.NewRequestID()
+	if c := connectionOf(conn); c != nil {
+		defer c.requestStarted()()
+	}
+	var decoded Msg
+	decodeStart := time.Now()
+	err := json.Unmarshal(postdata, &decoded)
+	decodeEnd := time.Now()
+	utils.FrameDecoded(err)
+	if err != nil {
+		utils.LoggerFrom(ctx).Warn("xxx TaskDeliver()", err)
+	}
+	if ShuttingDown() {
+		conn.Write(errorResponse(decoded.Content.ID, ErrCodeGoingAway, "server going away", nil))
+		return
+	}
+
+	// the spans begin when the frame was read, the app client may continue its trace by the traceparent in meta
+	receivedAt := utils.ReceivedAt(ctx)
+	traceparent, _ := decoded.Meta["traceparent"].(string)
+	tracestate, _ := decoded.Meta["tracestate"].(string)
+	ctx, span := utils.Tracer().Start(utils.ContextWithTraceparent(ctx, traceparent, tracestate), "TaskDeliver",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(receivedAt),
+		trace.WithAttributes(
+			attribute.String("rpc.method", decoded.Content.Method),
+			attribute.String("client.address", RemoteAddr(conn)),
+		))
+	defer span.End()
+	// the decode happened before the trace of the client was known, the span is recorded with its times
+	_, decode := utils.Tracer().Start(ctx, "frame.decode", trace.WithTimestamp(decodeStart), trace.WithAttributes(attribute.Int("frame.size", len(postdata))))
+	if err != nil {
+		decode.SetStatus(codes.Error, err.Error())
+	}
+	decode.End(trace.WithTimestamp(decodeEnd))
+
 	for _, v := range routers {
 		pred := v[0]
 		act := v[1]
-		var entermsg Msg
-		err := json.Unmarshal(postdata, &entermsg)
-		if err != nil {
-			utils.Log(err)
+		entermsg := decoded
+		// the principal in meta is set by the proxy only, so the routing rules can match it
+		if entermsg.Meta == nil {
+			entermsg.Meta = make(map[string]interface{})
 		}
+		delete(entermsg.Meta, "principal")
 
 		rpcRequest := entermsg.Content
-		utils.Log("xxx parsing the JSONRPC2.0 message from app client...")
-		id := rpcRequest.ID
-		utils.Log("xxx rpcRequest.id:", id)
-		jsonrpc := rpcRequest.JSONRPC
-		utils.Log("xxx rpcRequest.jsonrpc:", jsonrpc)
-		method := rpcRequest.Method
-		utils.Log("xxx rpcRequest.Method:", method)
-
-		f := rpcRequest.Params
-		key := f.(map[string]interface{})["key"].(string)
-		utils.Log("rpcRequest.Params.Key:", key)
-		channel := f.(map[string]interface{})["channel"].(string)
-		utils.Log("rpcRequest.Params.Channel:", channel)
+		params := requestParams(rpcRequest.Params)
+		log := utils.LoggerFrom(ctx).With(
+			utils.F("reqid", reqid),
+			utils.F("method", rpcRequest.Method),
+			utils.F("channel", params.Channel),
+		)
+		if span.SpanContext().IsValid() {
+			log = log.With(utils.F("traceid", span.SpanContext().TraceID().String()))
+		}
+		if principal := PrincipalFromContext(ctx); principal != nil {
+			entermsg.Meta["principal"] = principal.ID
+			log = log.With(utils.F("principal", principal.ID))
+		}
+		entermsg = entermsg.WithContext(utils.ContextWithLogger(ctx, log))
+		log.Debug("xxx rpcRequest.id:", rpcRequest.ID, "jsonrpc:", rpcRequest.JSONRPC, "key:", params.Key)
 
 		if pred.(func(entermsg Msg) bool)(entermsg) {
-			result := act.(Controller).Excute(entermsg)
-			utils.Log("sending result to app client: ", string(result))
+			route := routeName(act)
+			span.SetAttributes(attribute.String("route", route))
+			excuteCtx, excute := utils.Tracer().Start(entermsg.Context(), "Excute", trace.WithAttributes(attribute.String("route", route)))
+			result := withMiddlewares(act.(Controller)).Excute(entermsg.WithContext(excuteCtx))
+			code, _ := responseOutcome(result)
+			for _, s := range []trace.Span{excute, span} {
+				s.SetAttributes(attribute.Int("rpc.jsonrpc.error_code", code))
+				if code != 0 {
+					s.SetStatus(codes.Error, "json-rpc error "+strconv.Itoa(code))
+				}
+			}
+			excute.End()
+			utils.ObserveRequest(rpcRequest.Method, route, code, time.Since(start))
+			log.Debug("sending result to app client: ", string(result))
 			conn.Write(result)
+			log.Info("request done in", time.Since(start))
 			return
 		}
 	}
 }
 
+//requestParams is to pick the channel, key and value out of the params sent by app client.
+func requestParams(params interface{}) *MethodParams {
+	var mp MethodParams
+	data, err := json.Marshal(params)
+	if err != nil {
+		utils.Log(err)
+		return &mp
+	}
+	if err = json.Unmarshal(data, &mp); err != nil {
+		utils.Log(err)
+	}
+	// only the proxy signs
+	mp.Signature, mp.KeyID = "", ""
+	return &mp
+}
+
+//upstream is the block chain service the requests are sent to.
+type upstream struct {
+	url    string
+	apiKey string
+}
+
+var currentUpstream atomic.Value
+
+func init() {
+	currentUpstream.Store(upstream{url: utils.DefaultUpstream})
+	utils.KnownMethods(StateMethod, TransactionsMethod, WriteMethod, HistoryMethod, VerifyMethod)
+}
+
+//SetUpstream is to send the requests to the block chain service at rawURL, with apiKey as X-Api-Key when it is set,
+//from now on; the requests in flight aren't affected.
+func SetUpstream(rawURL string, apiKey string) {
+	currentUpstream.Store(upstream{url: rawURL, apiKey: apiKey})
+}
+
+//Upstream is the JSON-RPC endpoint of the block chain service in use.
+func Upstream() string {
+	return currentUpstream.Load().(upstream).url
+}
+
+//CheckUpstream is to check the block chain service accepts connections, within timeout.
+func CheckUpstream(timeout time.Duration) error {
+	endpoint, err := url.Parse(Upstream())
+	if err != nil {
+		return err
+	}
+	address := endpoint.Host
+	if endpoint.Port() == "" {
+		port := "80"
+		if endpoint.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(endpoint.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
 //sendJsonrpcRequest is to send request to block chain service.
-func sendJsonrpcRequest(method string) (*jsonrpc.RPCResponse, error) {
+func sendJsonrpcRequest(ctx context.Context, method string, params *MethodParams) (*jsonrpc.RPCResponse, error) {
 	var err error
 	//rpcClient := jsonrpc.NewClient("http://my-rpc-service:8080/rpc")
-	rpcClient := jsonrpc.NewClient("https://www.ninechain.net/api/v2")
+	up := currentUpstream.Load().(upstream)
+	opts := &jsonrpc.RPCClientOpts{}
+	if up.apiKey != "" {
+		opts.CustomHeaders = map[string]string{"X-Api-Key": up.apiKey}
+	}
+	rpcClient := jsonrpc.NewClientWithOpts(up.url, opts)
 	if rpcClient == nil {
 		utils.Log("rxxx sendJsonrpcRequest() pcClient is nil!")
 		return nil, err
 	}
-	rpcResp, err := rpcClient.Call(method, &MethodParams{Channel: "vvtrip", Key: "00000000000000000000000000000001"})
+	rpcResp, err := rpcClient.CallContext(ctx, method, params)
 	if err != nil {
 		utils.Log("xxx err for rpcClient.Call:", err.Error())
 		return nil, err
@@ -211,18 +442,18 @@ func verifyStateMsg(rpcResp *jsonrpc.RPCResponse) (bool, error) {
 
 	mirrormsg, err := json.Marshal(rpcResp)
 	utils.CheckError(err)
-	utils.Log("xxx verifyStateMsg() mirrormsg:", string(mirrormsg))
+	utils.LogDebug("xxx verifyStateMsg() mirrormsg:", string(mirrormsg))
 
 	var rpcRespState = new(RPCResponseState)
 	json.Unmarshal(mirrormsg, &rpcRespState)
 
 	id := rpcRespState.ID
-	utils.Log("xxx verifyStateMsg() rpcRespState.id:", id)
+	utils.LogDebug("xxx verifyStateMsg() rpcRespState.id:", id)
 	jsonrpc := rpcRespState.JSONRPC
-	utils.Log("xxx verifyStateMsg() rpcRespState.jsonrpc:", jsonrpc)
+	utils.LogDebug("xxx verifyStateMsg() rpcRespState.jsonrpc:", jsonrpc)
 	rpcresult := rpcRespState.Result
 	state := rpcresult.State
-	utils.Log("xxx verifyStateMsg() rpcRespState.Result.state:", state)
+	utils.LogDebug("xxx verifyStateMsg() rpcRespState.Result.state:", state)
 
 	return true, nil
 }
@@ -232,40 +463,43 @@ func verifyTransactionMsg(rpcResp *jsonrpc.RPCResponse) (bool, error) {
 
 	mirrormsg, err := json.Marshal(rpcResp)
 	utils.CheckError(err)
-	utils.Log("xxx verifyTransactionMsg() mirrormsg:", string(mirrormsg))
+	utils.LogDebug("xxx verifyTransactionMsg() mirrormsg:", string(mirrormsg))
 
 	var rpcRespTx = new(RPCResponseTransaction)
 	json.Unmarshal(mirrormsg, &rpcRespTx)
 
 	id := rpcRespTx.ID
-	utils.Log("xxx verifyTransactionMsg() rpcRespTx.id:", id)
+	utils.LogDebug("xxx verifyTransactionMsg() rpcRespTx.id:", id)
 	jsonrpc := rpcRespTx.JSONRPC
-	utils.Log("xxx verifyTransactionMsg() rpcRespTx.jsonrpc:", jsonrpc)
+	utils.LogDebug("xxx verifyTransactionMsg() rpcRespTx.jsonrpc:", jsonrpc)
 	rpcresults := rpcRespTx.Result
 	for i := range rpcresults {
 		//表示遍历数组，而i表示的是数组的下标值，
 		//result[i]表示获得第i个json对象即JSONObject
 		//result[i]通过.字段名称即可获得指定字段的值
 		tx_id := rpcresults[i].Tx_id
-		utils.Log("xxx verifyTransactionMsg() rpcRespTx.Result.Tx_id:", tx_id)
+		utils.LogDebug("xxx verifyTransactionMsg() rpcRespTx.Result.Tx_id:", tx_id)
 		value := rpcresults[i].Value
-		utils.Log("xxx verifyTransactionMsg() rpcRespTx.Result.Value:", value)
+		utils.LogDebug("xxx verifyTransactionMsg() rpcRespTx.Result.Value:", value)
 		timestamp := rpcresults[i].Timestamp
 		nanos := timestamp.Nanos
 		seconds := timestamp.Seconds
-		utils.Log("xxx verifyTransactionMsg() rpcRespTx.Result.Timestamp.Seconds:", seconds, "Nanos:", nanos)
+		utils.LogDebug("xxx verifyTransactionMsg() rpcRespTx.Result.Timestamp.Seconds:", seconds, "Nanos:", nanos)
 	}
 
 	return true, nil
 }
 
 func verifyMsg(method string, rpcResp *jsonrpc.RPCResponse) (bool, error) {
-	if method == "source-state" {
+	if method == StateMethod {
 		return verifyStateMsg(rpcResp)
 	} else {
-		if method == "source-transactions" {
+		if method == TransactionsMethod {
 			return verifyTransactionMsg(rpcResp)
 		}
+		if method == WriteMethod {
+			return rpcResp != nil, nil
+		}
 	}
 	return false, nil
 }
@@ -285,32 +519,58 @@ func (echoCtrl *EchoController) Excute(message Msg) []byte {
 	var entermsg Msg
 	err = json.Unmarshal(mirrormsg, &entermsg)
 	if err != nil {
-		utils.Log(err)
+		utils.LoggerFrom(message.Context()).Warn(err)
 	}
 
 	rpcRequest := entermsg.Content
-	utils.Log("xxx parsing the JSONRPC2.0 message from app client...")
-	/*
-		id := rpcRequest.ID
-		Log("xxx rpcRequest.id:", id)
-		jsonrpc := rpcRequest.JSONRPC
-		Log("xxx rpcRequest.jsonrpc:", jsonrpc)
-	*/
+	log := utils.LoggerFrom(message.Context())
 	method := rpcRequest.Method
-	utils.Log("xxx Excute() parsing Method:", method)
-	/*
-		f := rpcRequest.Params
-		key := f.(map[string]interface{})["key"].(string)
-		Log("rpcRequest.Params.Key:", key)
-		channel := f.(map[string]interface{})["channel"].(string)
-		Log("rpcRequest.Params.Channel:", channel)
-	*/
-	rpcResp, err := sendJsonrpcRequest(method)
+	log.Debug("xxx Excute() parsing Method:", method)
+	params := requestParams(rpcRequest.Params)
+	switch method {
+	case HistoryMethod:
+		history, err := History(message.Context(), params.Channel, params.Key, message.Principal())
+		if err != nil {
+			return errorResponse(rpcRequest.ID, ErrCodeInternal, err.Error(), nil)
+		}
+		respMsg, err := json.Marshal(&jsonrpc.RPCResponse{JSONRPC: "2.0", Result: history, ID: rpcRequest.ID})
+		utils.CheckError(err)
+		return respMsg
+	case VerifyMethod:
+		check, err := VerifyRecord(message.Context(), params.Channel, params.Key)
+		if err != nil {
+			return errorResponse(rpcRequest.ID, ErrCodeInternal, err.Error(), nil)
+		}
+		respMsg, err := json.Marshal(&jsonrpc.RPCResponse{JSONRPC: "2.0", Result: check, ID: rpcRequest.ID})
+		utils.CheckError(err)
+		return respMsg
+	case WriteMethod:
+		unlock := lockKey(params.Channel, params.Key)
+		defer unlock()
+		if err = amendWrite(message.Context(), params); err != nil {
+			log.Warn("xxx Excute() amendWrite:", err)
+			if _, ok := err.(*VersionConflictError); ok {
+				return errorResponse(rpcRequest.ID, ErrCodeVersionConflict, err.Error(), nil)
+			}
+			if _, ok := err.(*RecordKindError); ok {
+				return errorResponse(rpcRequest.ID, ErrCodeRecordKind, err.Error(), nil)
+			}
+			return errorResponse(rpcRequest.ID, ErrCodeInternal, err.Error(), nil)
+		}
+	}
+	rpcResp, err := sendJsonrpcRequest(message.Context(), method, params)
+	if err != nil || rpcResp == nil {
+		log.Error("xxx Excute() no response from block chain service:", err)
+		return errorResponse(rpcRequest.ID, ErrCodeInternal, "block chain service unavailable", nil)
+	}
 
 	isok, err := verifyMsg(method, rpcResp)
 	if isok {
+		if fieldCipher != nil {
+			fieldCipher.decryptResponse(message.Context(), message.Principal(), method, params, rpcResp.Result)
+		}
 		respMsg, err := json.Marshal(rpcResp)
-		utils.Log("echo the message:", string(respMsg))
+		log.Debug("echo the message:", string(respMsg))
 		utils.CheckError(err)
 		return respMsg
 	}
@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+/* Signing of the writes, for non-repudiation of the anchored records; in the signing section of config.yaml:
+   signing:
+     keystore: ./conf/signing.pem          # PKCS#8 Ed25519 or ECDSA P-256 key, e.g. openssl genpkey -algorithm ed25519
+     keyid: proxy-2026                     # the sha256 of the public key by default
+     trustedkeys:                          # public keys of the other proxies, for the verification
+       - ./conf/keys/proxy-2025.pem        # or a map of key id to file, for keys with their own keyid
+   The signature covers the channel, the key and the versioned record; it is stored in the record and sent as the
+   signature and keyId params:
+     {"version": 2, "prevTxId": "...", "record": {...}, "signature": {"alg": "ed25519", "keyId": "...", "value": "base64"}}
+   source-verify is answered by the proxy with the check of the signature of the current record.
+   写入签名：代理用本地密钥对每次写入签名，可通过source-verify校验链上记录的签名。
+*/
+
+//VerifyMethod is answered by the proxy itself with the signature check of the current record of a key.
+const VerifyMethod = "source-verify"
+
+//signature algorithms.
+const (
+	AlgEd25519   = "ed25519"
+	AlgECDSAP256 = "ecdsa-p256-sha256"
+)
+
+//RecordSignature is the signature stored in a versioned record.
+type RecordSignature struct {
+	Alg   string `json:"alg"`
+	KeyID string `json:"keyId"`
+	Value string `json:"value"`
+}
+
+//Keyring holds the signing key of the proxy and the public keys trusted for the verification.
+type Keyring struct {
+	KeyID   string
+	Alg     string
+	private crypto.Signer
+	trusted map[string]crypto.PublicKey
+}
+
+var keyring *Keyring
+
+//UseKeyring is to sign the writes and verify the records with k, nil turns signing off.
+func UseKeyring(k *Keyring) {
+	keyring = k
+}
+
+//NewKeyring is to read the signing section of the config, nil section means no signing.
+func NewKeyring(section map[interface{}]interface{}) (*Keyring, error) {
+	if section == nil {
+		return nil, nil
+	}
+	k := &Keyring{trusted: make(map[string]crypto.PublicKey)}
+	if path, ok := section["keystore"]; ok {
+		private, err := loadPrivateKey(fmt.Sprint(path))
+		if err != nil {
+			return nil, err
+		}
+		if k.Alg, err = algOf(private.Public()); err != nil {
+			return nil, fmt.Errorf("keystore %v: %v", path, err)
+		}
+		k.private = private
+		k.KeyID = fmt.Sprint(section["keyid"])
+		if _, ok := section["keyid"]; !ok {
+			if k.KeyID, err = keyIDOf(private.Public()); err != nil {
+				return nil, err
+			}
+		}
+		k.trusted[k.KeyID] = private.Public()
+	}
+	// a list of files has the default key ids, a map gives the key id of each file
+	trusted := make(map[string]string)
+	switch keys := section["trustedkeys"].(type) {
+	case []interface{}:
+		for _, path := range keys {
+			trusted[fmt.Sprint(path)] = ""
+		}
+	case map[interface{}]interface{}:
+		for id, path := range keys {
+			trusted[fmt.Sprint(path)] = fmt.Sprint(id)
+		}
+	}
+	for path, id := range trusted {
+		public, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = algOf(public); err != nil {
+			return nil, fmt.Errorf("trusted key %s: %v", path, err)
+		}
+		if id == "" {
+			if id, err = keyIDOf(public); err != nil {
+				return nil, err
+			}
+		}
+		k.trusted[id] = public
+	}
+	return k, nil
+}
+
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("keystore %s: unexpected pem block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keystore %s: %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("keystore %s: not a signing key", path)
+	}
+	return signer, nil
+}
+
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("trusted key %s: unexpected pem block %q", path, block.Type)
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("trusted key %s: %v", path, err)
+	}
+	return public, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem block found in %s", path)
+	}
+	return block, nil
+}
+
+func algOf(public crypto.PublicKey) (string, error) {
+	switch key := public.(type) {
+	case ed25519.PublicKey:
+		return AlgEd25519, nil
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return AlgECDSAP256, nil
+		}
+	}
+	return "", fmt.Errorf("only Ed25519 and ECDSA P-256 keys are supported")
+}
+
+//keyIDOf is the default key id, the first 16 bytes of the sha256 of the public key in hex.
+func keyIDOf(public crypto.PublicKey) (string, error) {
+	if _, err := algOf(public); err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+//signingPayload is what the signature covers: the channel, the key and the versioned record without its signature.
+func signingPayload(channel string, key string, vr VersionedRecord) ([]byte, error) {
+	vr.Signature = nil
+	record, err := json.Marshal(vr)
+	if err != nil {
+		return nil, err
+	}
+	payload := []byte(strconv.Quote(channel) + "\n" + strconv.Quote(key) + "\n")
+	return append(payload, record...), nil
+}
+
+//Sign is to set the signature of a record about to be written under key of channel.
+func (k *Keyring) Sign(channel string, key string, vr *VersionedRecord) error {
+	if k.private == nil {
+		return fmt.Errorf("no keystore to sign with")
+	}
+	payload, err := signingPayload(channel, key, *vr)
+	if err != nil {
+		return err
+	}
+	var sig []byte
+	switch k.Alg {
+	case AlgEd25519:
+		sig, err = k.private.Sign(rand.Reader, payload, crypto.Hash(0))
+	default:
+		digest := sha256.Sum256(payload)
+		sig, err = k.private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return err
+	}
+	vr.Signature = &RecordSignature{Alg: k.Alg, KeyID: k.KeyID, Value: base64.StdEncoding.EncodeToString(sig)}
+	return nil
+}
+
+//SignatureCheck is the result of verifying the signature of a stored record.
+type SignatureCheck struct {
+	Channel  string `json:"channel"`
+	Key      string `json:"key"`
+	Version  int    `json:"version"`
+	Signed   bool   `json:"signed"`
+	Verified bool   `json:"verified"`
+	Alg      string `json:"alg,omitempty"`
+	KeyID    string `json:"keyId,omitempty"`
+	// Reason tells why the signature isn't verified.
+	Reason string `json:"reason,omitempty"`
+}
+
+//Verify is to check the signature of value, stored under key of channel, against the trusted keys.
+func (k *Keyring) Verify(channel string, key string, value string) SignatureCheck {
+	check := SignatureCheck{Channel: channel, Key: key}
+	var vr VersionedRecord
+	if json.Unmarshal([]byte(value), &vr) != nil || vr.Record == nil {
+		check.Reason = "not a versioned record"
+		return check
+	}
+	check.Version = vr.Version
+	if vr.Signature == nil {
+		check.Reason = "not signed"
+		return check
+	}
+	check.Signed = true
+	check.Alg, check.KeyID = vr.Signature.Alg, vr.Signature.KeyID
+	public, ok := k.trusted[vr.Signature.KeyID]
+	if !ok {
+		check.Reason = "unknown key " + vr.Signature.KeyID
+		return check
+	}
+	if alg, _ := algOf(public); alg != vr.Signature.Alg {
+		check.Reason = "algorithm " + vr.Signature.Alg + " doesn't match the key"
+		return check
+	}
+	sig, err := base64.StdEncoding.DecodeString(vr.Signature.Value)
+	if err != nil {
+		check.Reason = "malformed signature"
+		return check
+	}
+	payload, err := signingPayload(channel, key, vr)
+	if err != nil {
+		check.Reason = err.Error()
+		return check
+	}
+	switch pub := public.(type) {
+	case ed25519.PublicKey:
+		check.Verified = ed25519.Verify(pub, payload, sig)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		check.Verified = ecdsa.VerifyASN1(pub, digest[:], sig)
+	}
+	if !check.Verified {
+		check.Reason = "signature mismatch"
+	}
+	return check
+}
+
+//VerifyRecord is to check the signature of the current record of key, read back with source-state.
+func VerifyRecord(ctx context.Context, channel string, key string) (*SignatureCheck, error) {
+	if keyring == nil {
+		return nil, fmt.Errorf("signing is not configured")
+	}
+	rpcResp, err := callUpstream(ctx, StateMethod, &MethodParams{Channel: channel, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	var state ResultState
+	if err = rpcResp.GetObject(&state); err != nil {
+		return nil, err
+	}
+	if state.State == "" {
+		return nil, ErrNotFound
+	}
+	check := keyring.Verify(channel, key, state.State)
+	return &check, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+//checkpoint remembers which keys of an import were already written, so a broken import can be resumed.
+type checkpoint struct {
+	path string
+	File string            `json:"file"`
+	Done map[string]string `json:"done"`
+}
+
+//loadCheckpoint is to read the checkpoint of a file, a new one is returned if it doesn't exist.
+func loadCheckpoint(path string, file string) (*checkpoint, error) {
+	cp := &checkpoint{path: path, File: file, Done: make(map[string]string)}
+	if path == "" {
+		return cp, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	if cp.Done == nil || cp.File != file {
+		// a checkpoint of another file is not resumed
+		cp.File = file
+		cp.Done = make(map[string]string)
+	}
+	return cp, nil
+}
+
+//mark is to record a written key and save the checkpoint at once.
+func (cp *checkpoint) mark(key string, result string) error {
+	cp.Done[key] = result
+	if cp.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write then rename, a crash never leaves a half written checkpoint
+	tmp := cp.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"goproxy4blockchain/handler"
+	"goproxy4blockchain/jsonrpc"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "soil.ckpt")
+	cp, err := loadCheckpoint(path, "soil.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Done) != 0 {
+		t.Errorf("new checkpoint %+v", cp)
+	}
+	if err = cp.mark("0001", `{"txId":"1"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err = loadCheckpoint(path, "soil.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"0001": `{"txId":"1"}`}; !reflect.DeepEqual(cp.Done, want) {
+		t.Errorf("done %v, want %v", cp.Done, want)
+	}
+	if cp, err = loadCheckpoint(path, "other.csv"); err != nil || len(cp.Done) != 0 {
+		t.Errorf("checkpoint of another file %+v, %v", cp, err)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadCheckpoint(path, "soil.csv"); err == nil {
+		t.Error("a broken checkpoint is read")
+	}
+}
+
+func TestResume(t *testing.T) {
+	records := []record{
+		{Key: "0001", Value: handler.ProductInfomation{}},
+		{Key: "0002", Value: handler.ProductInfomation{}},
+		{Key: "0003", Value: handler.ProductInfomation{}},
+	}
+	path := filepath.Join(t.TempDir(), "report.ckpt")
+	var written []string
+	failOn := "0002"
+	client := fakeProxy(t, time.Second, func(r request) *jsonrpc.RPCResponse {
+		if r.Method != handler.WriteMethod || r.Params.Kind != "product" || r.Params.Reason != "import report.csv" {
+			return &jsonrpc.RPCResponse{Error: &jsonrpc.RPCError{Code: -32601, Message: "unexpected " + r.Method}}
+		}
+		if r.Params.Key == failOn {
+			return &jsonrpc.RPCResponse{Error: &jsonrpc.RPCError{Code: -32603, Message: "upstream down"}}
+		}
+		written = append(written, r.Params.Key)
+		return &jsonrpc.RPCResponse{Result: map[string]interface{}{"txId": "tx" + r.Params.Key}}
+	})
+
+	cp, err := loadCheckpoint(path, "report.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = importRecords(client, records, cp, handler.WriteMethod, "product", "import report.csv"); err == nil {
+		t.Fatal("the failed write isn't reported")
+	}
+	if !reflect.DeepEqual(written, []string{"0001"}) {
+		t.Fatalf("written %v before the failure", written)
+	}
+
+	// the import is run again with the same checkpoint
+	failOn = ""
+	cp, err = loadCheckpoint(path, "report.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = importRecords(client, records, cp, handler.WriteMethod, "product", "import report.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, []string{"0001", "0002", "0003"}) {
+		t.Errorf("written %v, want each key once", written)
+	}
+	if want := map[string]string{"0001": `{"txId":"tx0001"}`, "0002": `{"txId":"tx0002"}`, "0003": `{"txId":"tx0003"}`}; !reflect.DeepEqual(cp.Done, want) {
+		t.Errorf("done %v, want %v", cp.Done, want)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"goproxy4blockchain/handler"
+	"strings"
+)
+
+/* The lab reports arrive as spreadsheets, the columns are the same as the tables in handler/parser.go:
+   序号	检验项目	计量单位	标准要求	实测值	单项结论
+   Each header (Chinese or English, case and blanks ignored) is mapped to a json field of the item.
+   An optional key column groups rows into several records, otherwise the whole file is one record.
+
+   表头支持中英文，行按key列分组，每组写入链上的一条记录。
+*/
+
+//keyField is the pseudo field holding the chain key of a row.
+const keyField = "key"
+
+var productHeaders = map[string]string{
+	"序号":                   "id",
+	"no":                   "id",
+	"id":                   "id",
+	"检验项目":                 "inspectionProject",
+	"inspectionproject":    "inspectionProject",
+	"item":                 "inspectionProject",
+	"计量单位":                 "measurementUnit",
+	"单位":                   "measurementUnit",
+	"unit":                 "measurementUnit",
+	"measurementunit":      "measurementUnit",
+	"标准要求":                 "standardRequirements",
+	"standard":             "standardRequirements",
+	"standardrequirements": "standardRequirements",
+	"实测值":                  "measuredValue",
+	"measured":             "measuredValue",
+	"measuredvalue":        "measuredValue",
+	"单项结论":                 "singleConclusion",
+	"conclusion":           "singleConclusion",
+	"singleconclusion":     "singleConclusion",
+	"批次":                   keyField,
+	"key":                  keyField,
+}
+
+var soilHeaders = map[string]string{
+	"样品编号":                     "smapleId",
+	"序号":                       "smapleId",
+	"sampleid":                 "smapleId",
+	"smapleid":                 "smapleId",
+	"检测项目":                     "sampleInspectionProject",
+	"检验项目":                     "sampleInspectionProject",
+	"inspectionproject":        "sampleInspectionProject",
+	"sampleinspectionproject":  "sampleInspectionProject",
+	"单位":                       "soilUnit",
+	"计量单位":                     "soilUnit",
+	"unit":                     "soilUnit",
+	"soilunit":                 "soilUnit",
+	"指标":                       "soilIndex",
+	"标准要求":                     "soilIndex",
+	"index":                    "soilIndex",
+	"soilindex":                "soilIndex",
+	"实测值":                      "soilMeasuredData",
+	"measured":                 "soilMeasuredData",
+	"soilmeasureddata":         "soilMeasuredData",
+	"检出限":                      "sampleNumDetectionLimit",
+	"detectionlimit":           "sampleNumDetectionLimit",
+	"samplenumdetectionlimit":  "sampleNumDetectionLimit",
+	"单项结论":                     "ln166872SingleConclusion",
+	"conclusion":               "ln166872SingleConclusion",
+	"singleconclusion":         "ln166872SingleConclusion",
+	"ln166872singleconclusion": "ln166872SingleConclusion",
+	"检测依据":                     "ln166872DetectionBasis",
+	"basis":                    "ln166872DetectionBasis",
+	"detectionbasis":           "ln166872DetectionBasis",
+	"ln166872detectionbasis":   "ln166872DetectionBasis",
+	"批次":                       keyField,
+	"key":                      keyField,
+}
+
+//record is one report to be stored under a chain key.
+type record struct {
+	Key   string
+	Value interface{}
+}
+
+//normalizeHeader drops blanks and case, "胶 稠 度" and "Measured Value" are both accepted.
+func normalizeHeader(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	return strings.NewReplacer(" ", "", "\u3000", "", "_", "", "-", "").Replace(h)
+}
+
+//mapColumns is to find the json field of each column, unknown columns are skipped.
+func mapColumns(header []string, aliases map[string]string) ([]string, error) {
+	fields := make([]string, len(header))
+	seen := make(map[string]bool)
+	for i, h := range header {
+		field, ok := aliases[normalizeHeader(h)]
+		if !ok {
+			continue
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("column %q maps to %s twice", h, field)
+		}
+		seen[field] = true
+		fields[i] = field
+	}
+	if len(seen) == 0 || (len(seen) == 1 && seen[keyField]) {
+		return nil, fmt.Errorf("no known column in header %v", header)
+	}
+	return fields, nil
+}
+
+//rowValues is to turn a row into field/value pairs.
+func rowValues(fields []string, row []string) map[string]string {
+	values := make(map[string]string)
+	for i, field := range fields {
+		if field == "" || i >= len(row) {
+			continue
+		}
+		values[field] = strings.TrimSpace(row[i])
+	}
+	return values
+}
+
+//buildRecords is to group the rows by key and build the report of the requested type.
+func buildRecords(kind string, rows [][]string, defaultKey string) ([]record, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("empty sheet")
+	}
+	aliases := productHeaders
+	if kind == "soil" {
+		aliases = soilHeaders
+	}
+	fields, err := mapColumns(rows[0], aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	groups := make(map[string][]map[string]string)
+	for n, row := range rows[1:] {
+		values := rowValues(fields, row)
+		if isBlank(values) {
+			continue
+		}
+		key := values[keyField]
+		if key == "" {
+			key = defaultKey
+		}
+		if key == "" {
+			return nil, fmt.Errorf("row %d: no key column and no -key given", n+2)
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], values)
+	}
+
+	records := make([]record, 0, len(keys))
+	for _, key := range keys {
+		if kind == "soil" {
+			report := handler.SoilCheckReport{}
+			for _, v := range groups[key] {
+				report.Items = append(report.Items, handler.SoilCheckReportItem{
+					SmapleId:                 v["smapleId"],
+					SampleInspectionProject:  v["sampleInspectionProject"],
+					SoilUnit:                 v["soilUnit"],
+					SoilIndex:                v["soilIndex"],
+					SoilMeasuredData:         v["soilMeasuredData"],
+					SampleNumDetectionLimit:  v["sampleNumDetectionLimit"],
+					LN166872SingleConclusion: v["ln166872SingleConclusion"],
+					LN166872DetectionBasis:   v["ln166872DetectionBasis"],
+				})
+			}
+			records = append(records, record{Key: key, Value: report})
+			continue
+		}
+		report := handler.ProductInfomation{}
+		for _, v := range groups[key] {
+			report.Items = append(report.Items, handler.ProductItem{
+				ID:                   v["id"],
+				InspectionProject:    v["inspectionProject"],
+				MeasurementUnit:      v["measurementUnit"],
+				StandardRequirements: v["standardRequirements"],
+				MeasuredValue:        v["measuredValue"],
+				SingleConclusion:     v["singleConclusion"],
+			})
+		}
+		records = append(records, record{Key: key, Value: report})
+	}
+	return records, nil
+}
+
+func isBlank(values map[string]string) bool {
+	for _, v := range values {
+		if v != "" {
+			return false
+		}
+	}
+	return true
+}
+
+//validate is to check the items of a record, all problems are returned together.
+func validate(r record) []string {
+	var problems []string
+	if len(r.Key) > 64 {
+		problems = append(problems, fmt.Sprintf("key %q is longer than 64", r.Key))
+	}
+	switch report := r.Value.(type) {
+	case handler.ProductInfomation:
+		ids := make(map[string]bool)
+		for i, item := range report.Items {
+			// sub items such as "糠粉" share the number of the item above, so only the name is required
+			if item.InspectionProject == "" {
+				problems = append(problems, fmt.Sprintf("items[%d]: missing 检验项目/inspectionProject", i))
+			}
+			if item.ID != "" && ids[item.ID+item.InspectionProject] {
+				problems = append(problems, fmt.Sprintf("items[%d]: duplicated item %s %s", i, item.ID, item.InspectionProject))
+			}
+			ids[item.ID+item.InspectionProject] = true
+			if item.MeasuredValue != "" && item.StandardRequirements == "" {
+				problems = append(problems, fmt.Sprintf("items[%d]: measured value without 标准要求/standardRequirements", i))
+			}
+		}
+	case handler.SoilCheckReport:
+		for i, item := range report.Items {
+			if item.SmapleId == "" {
+				problems = append(problems, fmt.Sprintf("items[%d]: missing 样品编号/sampleId", i))
+			}
+			if item.SampleInspectionProject == "" {
+				problems = append(problems, fmt.Sprintf("items[%d]: missing 检测项目/sampleInspectionProject", i))
+			}
+		}
+	}
+	return problems
+}
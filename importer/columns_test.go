@@ -0,0 +1,128 @@
+package main
+
+import (
+	"goproxy4blockchain/handler"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMapColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		aliases map[string]string
+		fields  []string
+		err     string
+	}{
+		{name: "chinese", header: []string{"序号", "检验项目", "计量单位", "标准要求", "实测值", "单项结论"}, aliases: productHeaders,
+			fields: []string{"id", "inspectionProject", "measurementUnit", "standardRequirements", "measuredValue", "singleConclusion"}},
+		{name: "english, case and blanks", header: []string{"No", " Item ", "Measured Value", "standard_requirements", "single-conclusion"}, aliases: productHeaders,
+			fields: []string{"id", "inspectionProject", "measuredValue", "standardRequirements", "singleConclusion"}},
+		{name: "ideographic space", header: []string{"检验　项目", "批 次"}, aliases: productHeaders,
+			fields: []string{"inspectionProject", keyField}},
+		{name: "unknown columns skipped", header: []string{"备注", "检验项目", ""}, aliases: productHeaders,
+			fields: []string{"", "inspectionProject", ""}},
+		{name: "soil aliases", header: []string{"样品编号", "检测项目", "检出限", "检测依据"}, aliases: soilHeaders,
+			fields: []string{"smapleId", "sampleInspectionProject", "sampleNumDetectionLimit", "ln166872DetectionBasis"}},
+		{name: "same header of both tables", header: []string{"序号", "检验项目"}, aliases: soilHeaders,
+			fields: []string{"smapleId", "sampleInspectionProject"}},
+		{name: "two aliases of a field", header: []string{"实测值", "Measured"}, aliases: productHeaders, err: "maps to measuredValue twice"},
+		{name: "no known column", header: []string{"备注", "日期"}, aliases: productHeaders, err: "no known column"},
+		{name: "key column only", header: []string{"批次"}, aliases: productHeaders, err: "no known column"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := mapColumns(tt.header, tt.aliases)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("fields %q, want %q", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestBuildRecords(t *testing.T) {
+	rows := [][]string{
+		{"批次", "序号", "检验项目", "标准要求", "实测值"},
+		{"0001", "1", "色泽", "正常", "正常"},
+		{"0002", "1", "水分", "≤14.5", " 13.2 "},
+		{"", "", "", "", ""},
+		{"0001", "", "糠粉", "≤0.15", "0.05"},
+		{"", "2", "杂质"},
+	}
+	records, err := buildRecords("product", rows, "0003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []record{
+		{Key: "0001", Value: handler.ProductInfomation{Items: []handler.ProductItem{
+			{ID: "1", InspectionProject: "色泽", StandardRequirements: "正常", MeasuredValue: "正常"},
+			{InspectionProject: "糠粉", StandardRequirements: "≤0.15", MeasuredValue: "0.05"},
+		}}},
+		{Key: "0002", Value: handler.ProductInfomation{Items: []handler.ProductItem{
+			{ID: "1", InspectionProject: "水分", StandardRequirements: "≤14.5", MeasuredValue: "13.2"},
+		}}},
+		{Key: "0003", Value: handler.ProductInfomation{Items: []handler.ProductItem{
+			{ID: "2", InspectionProject: "杂质"},
+		}}},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records %+v, want %+v", records, want)
+	}
+
+	if _, err := buildRecords("product", rows[:3], ""); err != nil {
+		t.Errorf("every row has a key: %v", err)
+	}
+	if _, err := buildRecords("product", rows, ""); err == nil || !strings.Contains(err.Error(), "row 6") {
+		t.Errorf("err %v, want row 6 without key", err)
+	}
+	if _, err := buildRecords("product", nil, "0001"); err == nil {
+		t.Error("an empty sheet is read")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   record
+		problems []string
+	}{
+		{name: "valid product", record: record{Key: "0001", Value: handler.ProductInfomation{Items: []handler.ProductItem{
+			{ID: "1", InspectionProject: "水分", StandardRequirements: "≤14.5", MeasuredValue: "13.2"},
+			{ID: "1", InspectionProject: "糠粉"},
+		}}}},
+		{name: "product problems", record: record{Key: strings.Repeat("0", 65), Value: handler.ProductInfomation{Items: []handler.ProductItem{
+			{ID: "1", InspectionProject: "水分"},
+			{ID: "1", InspectionProject: "水分", MeasuredValue: "13.2"},
+			{ID: "2"},
+		}}}, problems: []string{
+			"key \"" + strings.Repeat("0", 65) + "\" is longer than 64",
+			"items[1]: duplicated item 1 水分",
+			"items[1]: measured value without 标准要求/standardRequirements",
+			"items[2]: missing 检验项目/inspectionProject",
+		}},
+		{name: "soil problems", record: record{Key: "0001", Value: handler.SoilCheckReport{Items: []handler.SoilCheckReportItem{
+			{SmapleId: "S1", SampleInspectionProject: "镉"},
+			{SoilMeasuredData: "0.1"},
+		}}}, problems: []string{
+			"items[1]: missing 样品编号/sampleId",
+			"items[1]: missing 检测项目/sampleInspectionProject",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if problems := validate(tt.record); !reflect.DeepEqual(problems, tt.problems) {
+				t.Errorf("problems %q, want %q", problems, tt.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"goproxy4blockchain/handler"
+	"goproxy4blockchain/utils"
+	"os"
+	"time"
+)
+
+/* importer reads a lab report spreadsheet (csv or xlsx), validates it and writes it to the chain through goproxy4blockchain.
+   usage:
+     importer -file report.xlsx -type product -key 00000000000000000000000000000001 -dry-run
+     importer -file soil.csv -type soil -checkpoint soil.ckpt
+
+   批量导入大米检验报告/土壤检测报告, 先用 -dry-run 查看与链上数据的差异, 中断后使用同一个 -checkpoint 文件继续导入。
+*/
+
+func main() {
+	file := flag.String("file", "", "csv or xlsx file of the report")
+	kind := flag.String("type", "product", "record type of the rows: product or soil")
+	sheet := flag.String("sheet", "", "sheet of the xlsx file, the first one by default")
+	key := flag.String("key", "", "chain key of the record when the file has no key column")
+	server := flag.String("server", "localhost:10399", "address of goproxy4blockchain")
+	channel := flag.String("channel", "vvtrip", "chain channel of the records")
+	method := flag.String("method", handler.WriteMethod, "chain method used to store a record")
+	dryRun := flag.Bool("dry-run", false, "show the difference with the stored records without writing")
+	ckptPath := flag.String("checkpoint", "", "checkpoint file to resume an interrupted import")
+	reason := flag.String("reason", "", "reason of the amendment, \"import <file>\" by default")
+	useTLS := flag.Bool("tls", false, "connect to the proxy over TLS")
+	caFile := flag.String("ca", "", "CA certificate to verify the proxy, the system roots by default")
+	certFile := flag.String("cert", "", "client certificate for mTLS")
+	keyFile := flag.String("keyfile", "", "key of the client certificate")
+	clientID := flag.String("client-id", "", "client id to authenticate with the proxy")
+	apiKey := flag.String("apikey", "", "api key of the client")
+	secret := flag.String("secret", "", "shared secret of the client, used when there is no api key")
+	timeout := flag.Duration("timeout", 30*time.Second, "time to wait for each response of the proxy, 0 waits forever")
+	flag.Parse()
+
+	if *file == "" || (*kind != "product" && *kind != "soil") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rows, err := readRows(*file, *sheet)
+	if err != nil {
+		fail(err)
+	}
+	records, err := buildRecords(*kind, rows, *key)
+	if err != nil {
+		fail(err)
+	}
+
+	invalid := 0
+	for _, r := range records {
+		for _, problem := range validate(r) {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", r.Key, problem)
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		fail(fmt.Errorf("%d problems found in %s, nothing is written", invalid, *file))
+	}
+
+	var tlsConfig *tls.Config
+	if *useTLS {
+		tlsConfig, err = utils.ClientTLSConfig(utils.TLSFiles{CertFile: *certFile, KeyFile: *keyFile, CAFile: *caFile})
+		if err != nil {
+			fail(err)
+		}
+	}
+	client, err := dialProxy(*server, *channel, tlsConfig, *clientID, *apiKey, *secret, *timeout)
+	if err != nil {
+		fail(err)
+	}
+	defer client.Close()
+
+	if *dryRun {
+		os.Exit(showDiff(client, records))
+	}
+
+	if *reason == "" {
+		*reason = "import " + *file
+	}
+	cp, err := loadCheckpoint(*ckptPath, *file)
+	if err != nil {
+		fail(err)
+	}
+	if err = importRecords(client, records, cp, *method, *kind, *reason); err != nil {
+		fail(err)
+	}
+	fmt.Printf("%d records imported from %s\n", len(records), *file)
+}
+
+//importRecords is to write the records not in the checkpoint yet, marking each one as soon as it is written.
+func importRecords(client *proxyClient, records []record, cp *checkpoint, method string, kind string, reason string) error {
+	for i, r := range records {
+		progress := fmt.Sprintf("[%d/%d] %s", i+1, len(records), r.Key)
+		if _, done := cp.Done[r.Key]; done {
+			fmt.Println(progress, "skipped, already written")
+			continue
+		}
+		value, err := json.Marshal(r.Value)
+		if err != nil {
+			return err
+		}
+		result, err := client.write(method, r.Key, kind, string(value), reason)
+		if err != nil {
+			return fmt.Errorf("%s failed: %v, run again with the same -checkpoint to resume", progress, err)
+		}
+		if err = cp.mark(r.Key, result); err != nil {
+			return err
+		}
+		fmt.Println(progress, "ok", result)
+	}
+	return nil
+}
+
+//showDiff is to print what would be written, it returns 1 if anything would change.
+func showDiff(client *proxyClient, records []record) int {
+	changed := 0
+	for _, r := range records {
+		value, err := json.Marshal(r.Value)
+		if err != nil {
+			fail(err)
+		}
+		old, err := client.current(r.Key)
+		if err != nil {
+			fail(fmt.Errorf("read %s: %v", r.Key, err))
+		}
+		amendment, stored := handler.UnwrapRecord(old)
+		if old == "" {
+			stored = nil
+		}
+		changes, err := handler.DiffJSON(string(stored), string(value))
+		if err != nil {
+			fail(err)
+		}
+		if len(changes) == 0 {
+			fmt.Printf("= %s unchanged (version %d)\n", r.Key, amendment.Version)
+			continue
+		}
+		changed++
+		fmt.Printf("%s (%d changes to version %d)\n", r.Key, len(changes), amendment.Version)
+		for _, change := range changes {
+			fmt.Println("  " + change.String())
+		}
+	}
+	fmt.Printf("dry run: %d of %d records would change\n", changed, len(records))
+	if changed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "Fatal error: %s\n", err.Error())
+	os.Exit(1)
+}
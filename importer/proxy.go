@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"goproxy4blockchain/handler"
+	"goproxy4blockchain/jsonrpc"
+	"goproxy4blockchain/utils"
+	"net"
+	"strconv"
+	"time"
+)
+
+//proxyClient sends framed messages to goproxy4blockchain, the same way as appclient does.
+type proxyClient struct {
+	conn    net.Conn
+	decoder *json.Decoder
+	channel string
+	seq     int
+	// timeout is how long a response may take, 0 for no limit.
+	timeout time.Duration
+}
+
+//dialProxy is to connect to the proxy, and to authenticate when clientID is set; timeout bounds each response.
+func dialProxy(server string, channel string, tlsConfig *tls.Config, clientID string, apiKey string, secret string, timeout time.Duration) (*proxyClient, error) {
+	conn, err := utils.Dial(server, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if clientID != "" {
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		if err = utils.Authenticate(conn, clientID, apiKey, secret); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Time{})
+	}
+	return &proxyClient{conn: conn, decoder: json.NewDecoder(conn), channel: channel, timeout: timeout}, nil
+}
+
+func (client *proxyClient) Close() error {
+	return client.conn.Close()
+}
+
+//call is to send one JSON-RPC request through the proxy and wait for its response.
+func (client *proxyClient) call(method string, params *handler.MethodParams) (*jsonrpc.RPCResponse, error) {
+	client.seq++
+	message := &handler.Msg{
+		Meta: map[string]interface{}{
+			"meta": "test",
+			"ID":   strconv.Itoa(client.seq),
+		},
+		Content: jsonrpc.RPCRequest{
+			Method:  method,
+			Params:  params,
+			ID:      uint(client.seq),
+			JSONRPC: "2.0",
+		},
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = client.conn.Write(utils.Enpack(data)); err != nil {
+		return nil, err
+	}
+
+	// a proxy or upstream that hangs fails the call instead of the whole import
+	if client.timeout > 0 {
+		client.conn.SetReadDeadline(time.Now().Add(client.timeout))
+	}
+	var rpcResp jsonrpc.RPCResponse
+	if err = client.decoder.Decode(&rpcResp); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, fmt.Errorf("%s: no response in %v", method, client.timeout)
+		}
+		return nil, err
+	}
+	if rpcResp.Error != nil {
+		return nil, rpcResp.Error
+	}
+	return &rpcResp, nil
+}
+
+//current is to read the value stored under key, "" if nothing is stored yet.
+func (client *proxyClient) current(key string) (string, error) {
+	rpcResp, err := client.call(handler.StateMethod, &handler.MethodParams{Channel: client.channel, Key: key})
+	if err != nil {
+		return "", err
+	}
+	var result handler.ResultState
+	if err = rpcResp.GetObject(&result); err != nil {
+		return "", err
+	}
+	return result.State, nil
+}
+
+//write is to store value of kind under key and return the upstream result, the proxy adds the version.
+func (client *proxyClient) write(method string, key string, kind string, value string, reason string) (string, error) {
+	rpcResp, err := client.call(method, &handler.MethodParams{Channel: client.channel, Key: key, Kind: kind, Value: value, Reason: reason})
+	if err != nil {
+		return "", err
+	}
+	if rpcResp.Result == nil {
+		return "", fmt.Errorf("%s %s: empty result", method, key)
+	}
+	result, err := json.Marshal(rpcResp.Result)
+	return string(result), err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"goproxy4blockchain/handler"
+	"goproxy4blockchain/jsonrpc"
+	"goproxy4blockchain/utils"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+//request is a request the fake proxy received.
+type request struct {
+	Method string
+	Params handler.MethodParams
+}
+
+//fakeProxy is a proxy answering the requests on a pipe with answer, a nil response isn't answered.
+func fakeProxy(t *testing.T, timeout time.Duration, answer func(request) *jsonrpc.RPCResponse) *proxyClient {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	go func() {
+		buffer := make([]byte, 4096)
+		var rest []byte
+		for {
+			n, err := server.Read(buffer)
+			if err != nil {
+				return
+			}
+			var messages [][]byte
+			messages, rest, _ = utils.Unpack(append(rest, buffer[:n]...), 1<<20)
+			for _, message := range messages {
+				var entermsg struct {
+					Content struct {
+						Method string               `json:"method"`
+						Params handler.MethodParams `json:"params"`
+						ID     uint                 `json:"id"`
+					} `json:"content"`
+				}
+				if err := json.Unmarshal(message, &entermsg); err != nil {
+					return
+				}
+				rpcResp := answer(request{Method: entermsg.Content.Method, Params: entermsg.Content.Params})
+				if rpcResp == nil {
+					continue
+				}
+				rpcResp.JSONRPC, rpcResp.ID = "2.0", entermsg.Content.ID
+				data, _ := json.Marshal(rpcResp)
+				if _, err := server.Write(data); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return &proxyClient{conn: client, decoder: json.NewDecoder(client), channel: "vvtrip", timeout: timeout}
+}
+
+func TestCall(t *testing.T) {
+	client := fakeProxy(t, time.Second, func(r request) *jsonrpc.RPCResponse {
+		if r.Params.Key == "missing" {
+			return &jsonrpc.RPCResponse{Error: &jsonrpc.RPCError{Code: -32602, Message: "no record"}}
+		}
+		return &jsonrpc.RPCResponse{Result: map[string]interface{}{"state": r.Method + " " + r.Params.Channel + " " + r.Params.Key}}
+	})
+	state, err := client.current("0001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != handler.StateMethod+" vvtrip 0001" {
+		t.Errorf("state %q", state)
+	}
+	if _, err := client.current("missing"); err == nil || !strings.Contains(err.Error(), "no record") {
+		t.Errorf("err %v, want the error of the proxy", err)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	client := fakeProxy(t, 50*time.Millisecond, func(request) *jsonrpc.RPCResponse { return nil })
+	done := make(chan error)
+	go func() {
+		_, err := client.current("0001")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "no response in 50ms") {
+			t.Errorf("err %v, want a timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call waits for ever")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+//readRows is to read all the rows of a csv or xlsx file, the first row is the header.
+func readRows(path string, sheet string) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readCSV(path)
+	case ".xlsx":
+		return readXLSX(path, sheet)
+	}
+	return nil, fmt.Errorf("unsupported file type %s, use .csv or .xlsx", path)
+}
+
+func readCSV(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	// excel saves utf-8 csv with a BOM
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+	return rows, nil
+}
+
+func readXLSX(path string, sheet string) ([][]string, error) {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if sheet == "" {
+		sheet = f.GetSheetName(0)
+	}
+	return f.GetRows(sheet)
+}
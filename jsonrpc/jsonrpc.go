@@ -3,6 +3,7 @@ package jsonrpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"goproxy4blockchain/utils"
@@ -10,6 +11,13 @@ import (
 	"net/http"
 	"reflect"
 	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -47,6 +55,10 @@ type RPCClient interface {
 	// for more information, see the examples or the unit tests
 	Call(method string, params ...interface{}) (*RPCResponse, error)
 
+	// CallContext is Call within ctx: the request is canceled with ctx and continues the trace of ctx,
+	// the traceparent is sent in the http headers.
+	CallContext(ctx context.Context, method string, params ...interface{}) (*RPCResponse, error)
+
 	// CallFor is a very handy function to send a JSON-RPC request to the server endpoint
 	// and directly specify an object to store the response.
 	//
@@ -192,6 +204,11 @@ func NewClientWithOpts(endpoint string, opts *RPCClientOpts) RPCClient {
 
 //--
 func (client *rpcClient) Call(method string, params ...interface{}) (*RPCResponse, error) {
+	return client.CallContext(context.Background(), method, params...)
+}
+
+//--
+func (client *rpcClient) CallContext(ctx context.Context, method string, params ...interface{}) (*RPCResponse, error) {
 
 	request := &RPCRequest{
 		ID:      defaultID,
@@ -200,7 +217,7 @@ func (client *rpcClient) Call(method string, params ...interface{}) (*RPCRespons
 		JSONRPC: jsonrpcVersion,
 	}
 
-	return client.doCall(request)
+	return client.doCall(ctx, request)
 }
 
 func (client *rpcClient) CallFor(out interface{}, method string, params ...interface{}) error {
@@ -218,7 +235,7 @@ func (client *rpcClient) CallFor(out interface{}, method string, params ...inter
 }
 
 //--
-func (client *rpcClient) newRequest(req interface{}) (*http.Request, error) {
+func (client *rpcClient) newRequest(ctx context.Context, req interface{}) (*http.Request, error) {
 
 	body, err := json.Marshal(req)
 	if err != nil {
@@ -228,40 +245,59 @@ func (client *rpcClient) newRequest(req interface{}) (*http.Request, error) {
 	//B_chenhui
 	//utils.Log("xxx rpcClient :body is: ", body)
 
-	request, err := http.NewRequest("POST", client.endpoint, bytes.NewReader(body))
+	request, err := http.NewRequestWithContext(ctx, "POST", client.endpoint, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
 	request.Header.Set("Content-Type", "application/json")
 	//request.Header.Set("Accept", "application/json")//chenhui
-	request.Header.Set("X-Api-Key", "7a79d668d61993119516d7c898aa072bb971467752e3e7bb2751cc474080dbdb") //chenhui
 
 	// set default headers first, so that even content type and accept can be overwritten
 	for k, v := range client.customHeaders {
 		request.Header.Set(k, v)
 	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
 
 	return request, nil
 }
 
 //--
-func (client *rpcClient) doCall(RPCRequest *RPCRequest) (*RPCResponse, error) {
+func (client *rpcClient) doCall(ctx context.Context, RPCRequest *RPCRequest) (*RPCResponse, error) {
+	ctx, span := utils.Tracer().Start(ctx, "jsonrpc.doCall", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("rpc.method", RPCRequest.Method),
+		attribute.String("server.address", client.endpoint),
+	))
+	defer span.End()
+	rpcResponse, err := client.send(ctx, RPCRequest)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if rpcResponse.Error != nil {
+		span.SetAttributes(attribute.Int("rpc.jsonrpc.error_code", rpcResponse.Error.Code))
+		span.SetStatus(codes.Error, rpcResponse.Error.Message)
+	}
+	return rpcResponse, err
+}
 
-	httpRequest, err := client.newRequest(RPCRequest)
+//--
+func (client *rpcClient) send(ctx context.Context, RPCRequest *RPCRequest) (*RPCResponse, error) {
+	log := utils.LoggerFrom(ctx)
+	httpRequest, err := client.newRequest(ctx, RPCRequest)
 	if err != nil {
-		return nil, fmt.Errorf("rpc call %v() on %v: %v", RPCRequest.Method, httpRequest.URL.String(), err.Error())
+		return nil, fmt.Errorf("rpc call %v() on %v: %v", RPCRequest.Method, client.endpoint, err.Error())
 	}
+	start := time.Now()
 	httpResponse, err := client.httpClient.Do(httpRequest)
-	utils.Log("xxx doCall :httpResponse is:", httpResponse.Body) //chenhui
-	result, _ := ioutil.ReadAll(httpResponse.Body)
-	utils.Log("xxx doCall() response is:", string(result))
-
 	if err != nil {
-		utils.Log("xxx doCall :httpResponse is error") //chenhui
+		utils.ObserveUpstream(client.endpoint, RPCRequest.Method, time.Since(start), err)
+		log.Error("xxx doCall :httpResponse is error") //chenhui
 		return nil, fmt.Errorf("rpc call %v() on %v: %v", RPCRequest.Method, httpRequest.URL.String(), err.Error())
 	}
 	defer httpResponse.Body.Close()
+	result, readErr := ioutil.ReadAll(httpResponse.Body)
+	utils.ObserveUpstream(client.endpoint, RPCRequest.Method, time.Since(start), readErr)
+	log.Debug("xxx doCall() response is:", string(result))
 
 	var rpcResponse *RPCResponse
 
@@ -269,10 +305,10 @@ func (client *rpcClient) doCall(RPCRequest *RPCRequest) (*RPCResponse, error) {
 	//buf := make([]byte, 1024)
 	//httpResponse.Body.Read(buf)
 	json.Unmarshal(result, &rpcResp)
-	utils.Log("xxx doCall() rpcResp:", rpcResp)
+	log.Debug("xxx doCall() rpcResp:", rpcResp)
 
 	mirrormsg, err := json.Marshal(rpcResp)
-	utils.Log("xxx doCall() mirrormsg:", string(mirrormsg))
+	log.Debug("xxx doCall() mirrormsg:", string(mirrormsg))
 	/*
 		id := rpcResp.ID
 		utils.Log("xxx rpcResp.id:%v\n", id)
@@ -284,7 +320,7 @@ func (client *rpcClient) doCall(RPCRequest *RPCRequest) (*RPCResponse, error) {
 	*/
 
 	rpcResponse = rpcResp
-	utils.Log("xxx doCall() rpcResponse:", rpcResponse)
+	log.Debug("xxx doCall() rpcResponse:", rpcResponse)
 	/*
 		decoder := json.NewDecoder(httpResponse.Body)
 		decoder.DisallowUnknownFields()
@@ -294,7 +330,7 @@ func (client *rpcClient) doCall(RPCRequest *RPCRequest) (*RPCResponse, error) {
 
 	// parsing error
 	if err != nil && err.Error() != "EOF" {
-		utils.Log("xxx doCall :httpResponse parsing error.....") //chenhui
+		log.Error("xxx doCall :httpResponse parsing error.....") //chenhui
 		// if we have some http error, return it
 		if httpResponse.StatusCode >= 400 {
 			return nil, &HTTPError{
@@ -302,13 +338,13 @@ func (client *rpcClient) doCall(RPCRequest *RPCRequest) (*RPCResponse, error) {
 				err:  fmt.Errorf("rpc call %v() on %v status code: %v. could not decode body to rpc response: %v", RPCRequest.Method, httpRequest.URL.String(), httpResponse.StatusCode, err.Error()),
 			}
 		}
-		fmt.Printf("rpc call %v() on %v status code: %v. could not decode body to rpc response: %v", RPCRequest.Method, httpRequest.URL.String(), httpResponse.StatusCode, err.Error()) //chenhui
+		log.Error(fmt.Sprintf("rpc call %v() on %v status code: %v. could not decode body to rpc response: %v", RPCRequest.Method, httpRequest.URL.String(), httpResponse.StatusCode, err.Error())) //chenhui
 		return nil, fmt.Errorf("rpc call %v() on %v status code: %v. could not decode body to rpc response: %v", RPCRequest.Method, httpRequest.URL.String(), httpResponse.StatusCode, err.Error())
 	}
 
 	// response body empty
 	if rpcResponse == nil {
-		utils.Log("xxx doCall :rpcResponse is null .....") //chenhui
+		log.Error("xxx doCall :rpcResponse is null .....") //chenhui
 		// if we have some http error, return it
 		if httpResponse.StatusCode >= 400 {
 			return nil, &HTTPError{
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"goproxy4blockchain/handler"
+	"goproxy4blockchain/utils"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+/* usage:
+     server [serve] -config ./conf/config.yaml -listen 0.0.0.0:10399 -loglevel debug -upstream https://... -gomaxprocs 8
+     server config validate -config ./conf/config.yaml
+     server config print -config ./conf/config.yaml --effective
+     server version
+     server routes list
+
+   serve is the default, so "server -config x.yaml" still starts the proxy.
+   The flags override the environment, which overrides the config file; the config reload keeps them.
+   With listeners, -listen is the address of the first framed tcp listener, or of a new one named listen without any.
+   config validate reads the keys, certificates and policies as serve does and exits with 1 when the config is wrong.
+   命令行：启动服务、校验和打印配置、查看版本和路由表。
+*/
+
+//exitUsage is a wrong command line.
+const exitUsage = 2
+
+//version is set when building, go build -ldflags "-X main.version=1.4.0".
+var version = "dev"
+
+//run is to execute the command line args, it returns the exit status.
+func run(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return serve(args)
+	}
+	switch args[0] {
+	case "serve":
+		return serve(args[1:])
+	case "config":
+		if len(args) > 1 && args[1] == "validate" {
+			return validateConfig(args[2:])
+		}
+		if len(args) > 1 && args[1] == "print" {
+			return printConfig(args[2:])
+		}
+	case "version":
+		return printVersion()
+	case "routes":
+		if len(args) > 1 && args[1] == "list" {
+			return listRoutes()
+		}
+	case "help":
+		usage()
+		return exitOK
+	}
+	usage()
+	return exitUsage
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: server [serve] [-config file] [-listen host:port] [-loglevel level] [-upstream url] [-gomaxprocs n]")
+	fmt.Fprintln(os.Stderr, "       server config validate|print [-config file] [--effective]")
+	fmt.Fprintln(os.Stderr, "       server version")
+	fmt.Fprintln(os.Stderr, "       server routes list")
+	fmt.Fprintln(os.Stderr, "server <command> -h for the flags")
+}
+
+//configFlag is the -config flag of the commands reading the config.
+func configFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", "./conf/config.yaml", "config file, .yaml, .json, .toml or .xml; PROXY_* environment variables override it")
+}
+
+//overrides are the flags changing the config.
+type overrides struct {
+	listen   string
+	logLevel string
+	upstream string
+}
+
+func (o *overrides) register(flags *flag.FlagSet) {
+	flags.StringVar(&o.listen, "listen", "", "address the app clients connect to, overrides host or the address of the first framed tcp listener")
+	flags.StringVar(&o.logLevel, "loglevel", "", "debug, info, warn or error, overrides logging.level")
+	flags.StringVar(&o.upstream, "upstream", "", "JSON-RPC endpoint of the block chain service, overrides upstream")
+}
+
+//apply is to change config by the flags set.
+func (o overrides) apply(config *utils.Config) {
+	if o.listen != "" {
+		config.Host = o.listen
+		// host is ignored with listeners, the flag moves the first framed tcp one
+		listener := listenerOf(config)
+		if listener == nil && len(config.Listeners) > 0 {
+			config.Listeners = append(config.Listeners, utils.ListenerConfig{Name: "listen", Network: "tcp", Protocol: utils.ProtocolFramed})
+			listener = &config.Listeners[len(config.Listeners)-1]
+		}
+		if listener != nil {
+			listener.Address = o.listen
+		}
+	}
+	if o.logLevel != "" {
+		if config.Logging == nil {
+			config.Logging = &utils.LoggingConfig{}
+		}
+		config.Logging.Level = o.logLevel
+	}
+	if o.upstream != "" {
+		config.Upstream = o.upstream
+	}
+}
+
+//listenerOf is the first framed tcp listener of config, nil without one.
+func listenerOf(config *utils.Config) *utils.ListenerConfig {
+	for i := range config.Listeners {
+		l := &config.Listeners[i]
+		if (l.Network == "" || l.Network == "tcp") && (l.Protocol == "" || l.Protocol == utils.ProtocolFramed) {
+			return l
+		}
+	}
+	return nil
+}
+
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configpath := configFlag(flags)
+	var o overrides
+	o.register(flags)
+	gomaxprocs := flags.Int("gomaxprocs", 0, "threads running go code at once, 0 for the number of CPUs")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *gomaxprocs > 0 {
+		runtime.GOMAXPROCS(*gomaxprocs)
+	}
+	return startServer(*configpath, o.apply)
+}
+
+//validateConfig is to check the config as serve would read it, without listening.
+func validateConfig(args []string) int {
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	configpath := configFlag(flags)
+	var o overrides
+	o.register(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	config, err := utils.LoadConfig(*configpath, o.apply)
+	if err == nil {
+		err = checkSections(config)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return exitFatal
+	}
+	fmt.Printf("%s: config ok\n", *configpath)
+	return exitOK
+}
+
+//checkSections is to read the sections as startServer does: the policies, keys and certificates.
+func checkSections(config *utils.Config) error {
+	configmap := config.Map()
+	if _, err := readReloadable(configmap); err != nil {
+		return err
+	}
+	for _, lc := range config.EffectiveListeners() {
+		if lc.TLS == nil {
+			continue
+		}
+		if _, err := utils.ServerTLSConfig(tlsFiles(lc.TLS)); err != nil {
+			return fmt.Errorf("listener %s: %v", lc.Name, err)
+		}
+	}
+	if _, err := handler.NewAuthenticator(utils.GetSection("auth", configmap)); err != nil {
+		return err
+	}
+	if _, err := handler.NewFieldCipher(utils.GetSection("encryption", configmap)); err != nil {
+		return err
+	}
+	if _, err := handler.NewKeyring(utils.GetSection("signing", configmap)); err != nil {
+		return err
+	}
+	return nil
+}
+
+//printConfig is to write the config as yaml, with --effective the defaults, environment and flags applied.
+func printConfig(args []string) int {
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	configpath := configFlag(flags)
+	effective := flags.Bool("effective", false, "print the config in use: defaults, environment and flags applied")
+	secrets := flags.Bool("secrets", false, "print the api keys and secrets of the clients and the upstream instead of masking them")
+	var o overrides
+	o.register(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	configmap := make(map[interface{}]interface{})
+	if *effective {
+		config, err := utils.LoadConfig(*configpath, o.apply)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return exitFatal
+		}
+		configmap = config.Map()
+	} else {
+		data, _, err := utils.ReadConfigYAML(*configpath)
+		if err == nil {
+			err = yaml.Unmarshal(data, &configmap)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return exitFatal
+		}
+	}
+	if !*secrets {
+		if _, ok := configmap["upstreamapikey"]; ok {
+			configmap["upstreamapikey"] = "****"
+		}
+		for _, client := range utils.GetSection("clients", utils.GetSection("auth", configmap)) {
+			if entry, ok := client.(map[interface{}]interface{}); ok {
+				for _, key := range []string{"apikey", "secret"} {
+					if _, ok := entry[key]; ok {
+						entry[key] = "****"
+					}
+				}
+			}
+		}
+	}
+	out, err := yaml.Marshal(configmap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return exitFatal
+	}
+	os.Stdout.Write(out)
+	return exitOK
+}
+
+//printVersion is to print the version and what the binary was built from.
+func printVersion() int {
+	fmt.Println("goproxy4blockchain", version)
+	fmt.Printf("  go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return exitOK
+	}
+	settings := make(map[string]string)
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	if revision := settings["vcs.revision"]; revision != "" {
+		if settings["vcs.modified"] == "true" {
+			revision += " (modified)"
+		}
+		fmt.Printf("  revision: %s\n", revision)
+	}
+	if built := settings["vcs.time"]; built != "" {
+		fmt.Printf("  time:     %s\n", built)
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		fmt.Printf("  module:   %s %s\n", info.Main.Path, info.Main.Version)
+	}
+	return exitOK
+}
+
+//listRoutes is to print the routes in the order they are tried.
+func listRoutes() int {
+	routes, _ := handler.RouteTable()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tCONTROLLER")
+	for _, route := range routes {
+		fmt.Fprintf(w, "%s\t%s\n", route.Rule, route.Controller)
+	}
+	w.Flush()
+	return exitOK
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"goproxy4blockchain/handler"
+	"goproxy4blockchain/utils"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+/* The proxy listens on the listeners of config.yaml, all of them sharing the router:
+   listeners:
+     - name: public
+       address: 0.0.0.0:10399
+       tls: {certfile: ./conf/server.crt, keyfile: ./conf/server.key}
+       auth: {required: true}
+       maxconnections: 1000
+     - name: sidecar
+       network: unix
+       address: /run/goproxy/proxy.sock
+       mode: "0660"
+       auth: {required: false}
+       beatinginterval: 60
+     - name: web
+       address: 0.0.0.0:10443
+       protocol: websocket
+     - name: admin
+       network: unix
+       address: /run/goproxy/admin.sock
+       protocol: admin
+   Without listeners the proxy listens on host, with tls, as before.
+   多监听：同一进程同时提供TCP、TLS、Unix socket、websocket和本地运维socket，各自配置认证、连接数和心跳。
+*/
+
+//listener is a listener of the config, open.
+type listener struct {
+	net.Listener
+	config utils.ListenerConfig
+	auth   *handler.Authenticator
+	// slots has a value for each connection open, it is nil without maxconnections
+	slots chan struct{}
+}
+
+//openListeners is to listen on all the listeners of config; none is left open on error.
+func openListeners(config *utils.Config, auth *handler.Authenticator) ([]*listener, error) {
+	var listeners []*listener
+	for _, lc := range config.EffectiveListeners() {
+		l, err := openListener(lc, auth)
+		if err != nil {
+			closeListeners(listeners, "")
+			return nil, fmt.Errorf("listener %s: %v", lc.Name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func openListener(lc utils.ListenerConfig, auth *handler.Authenticator) (*listener, error) {
+	if lc.Network == "unix" {
+		if err := removeStaleSocket(lc.Address); err != nil {
+			return nil, err
+		}
+	}
+	netListen, err := net.Listen(lc.Network, lc.Address)
+	if err != nil {
+		return nil, err
+	}
+	if lc.Mode != "" {
+		mode, _ := strconv.ParseUint(lc.Mode, 8, 32)
+		if err = os.Chmod(lc.Address, os.FileMode(mode)); err != nil {
+			netListen.Close()
+			return nil, err
+		}
+	}
+	if lc.TLS != nil {
+		tlsConfig, err := utils.ServerTLSConfig(tlsFiles(lc.TLS))
+		if err != nil {
+			netListen.Close()
+			return nil, err
+		}
+		netListen = tls.NewListener(netListen, tlsConfig)
+		utils.Log("TLS enabled on", lc.Name, "client certificates:", tlsConfig.ClientAuth)
+	}
+	l := &listener{Listener: netListen, config: lc, auth: auth}
+	if a := lc.Auth; a != nil {
+		required, timeout, mtls := auth.Required, auth.Timeout, auth.MTLS
+		if a.Required != nil {
+			required = *a.Required
+		}
+		if a.Timeout > 0 {
+			timeout = time.Duration(a.Timeout) * time.Second
+		}
+		if a.MTLS != nil {
+			mtls = *a.MTLS
+		}
+		l.auth = auth.WithOptions(required, timeout, mtls)
+	}
+	if lc.MaxConnections > 0 {
+		l.slots = make(chan struct{}, lc.MaxConnections)
+	}
+	if lc.Protocol == utils.ProtocolWebSocket {
+		utils.Log("Listening on", lc.Network, lc.Address+lc.Path, "for", lc.Protocol, "as", lc.Name)
+	} else {
+		utils.Log("Listening on", lc.Network, lc.Address, "for", lc.Protocol, "as", lc.Name)
+	}
+	return l, nil
+}
+
+//tlsFiles are the files of the tls of a listener.
+func tlsFiles(t *utils.TLSConfig) utils.TLSFiles {
+	return utils.TLSFiles{CertFile: t.CertFile, KeyFile: t.KeyFile, CAFile: t.ClientCAFile, ClientAuth: t.ClientAuth}
+}
+
+//removeStaleSocket is to remove the unix socket left by a proxy which didn't shut down, not the one of a running proxy.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and isn't a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+//serve is to accept the connections of l until it is closed, admin serves the admin protocol.
+func (l *listener) serve(admin *handler.AdminServer) {
+	switch l.config.Protocol {
+	case utils.ProtocolAdmin:
+		if err := http.Serve(l, admin); err != nil && !errors.Is(err, net.ErrClosed) {
+			utils.LogErr("xxx admin listener", l.config.Name, err)
+		}
+	case utils.ProtocolWebSocket:
+		if err := http.Serve(l, webSocketHandler(l)); err != nil && !errors.Is(err, net.ErrClosed) {
+			utils.LogErr("xxx websocket listener", l.config.Name, err)
+		}
+	default:
+		acceptConnections(l)
+	}
+}
+
+//acceptConnections is to serve the app clients until the listener is closed.
+func acceptConnections(l *listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+			default:
+				utils.With(utils.F("listener", l.config.Name)).Warn("xxx", l.config.MaxConnections, "connections open, closing", handler.RemoteAddr(conn))
+				utils.ConnectionRejected(l.config.Name)
+				conn.Close()
+				continue
+			}
+		}
+
+		utils.Log(handler.RemoteAddr(conn), " "+l.config.Network+" connect success on", l.config.Name)
+		go func() {
+			handleConnection(conn, l.config, l.auth)
+			if l.slots != nil {
+				<-l.slots
+			}
+		}()
+	}
+}
+
+//closeListeners is to close the listeners of protocol, all of them when protocol is empty.
+func closeListeners(listeners []*listener, protocol string) {
+	for _, l := range listeners {
+		if protocol == "" || l.config.Protocol == protocol {
+			l.Close()
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"goproxy4blockchain/handler"
 	"goproxy4blockchain/utils"
 	"net"
-	"runtime"
-	"strconv"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 //MethodParams for JSON-RPC 2.0 parameters.
@@ -14,26 +18,116 @@ type MethodParams struct {
 	Key     string `json:"key"`
 }
 
-func startServer(configpath string) {
-	//	setup a socket and listen the port
-	configmap := utils.GetYamlConfig(configpath)
-	host := utils.GetElement("host", configmap)
-	timeinterval, err := strconv.Atoi(utils.GetElement("beatinginterval", configmap))
-	utils.CheckError(err)
-	netListen, err := net.Listen("tcp", host)
-	utils.CheckError(err)
-	defer netListen.Close()
-	utils.Log("Waiting for clients")
+//exit status of the proxy.
+const (
+	exitOK = 0
+	// exitFatal is a wrong config or a failure to start, or to write out the audit log.
+	exitFatal = 1
+	// exitDrainTimeout is a shutdown with requests still in flight.
+	exitDrainTimeout = 2
+)
 
-	for {
-		conn, err := netListen.Accept()
-		if err != nil {
-			continue
-		}
+//auditGrace is how long the requests still in flight after the drain timeout have to be audited.
+const auditGrace = 5 * time.Second
 
-		utils.Log(conn.RemoteAddr().String(), " tcp connect success")
-		go handleConnection(conn, timeinterval)
+//startServer is to serve the app clients until SIGINT or SIGTERM, override is the command line flags.
+func startServer(configpath string, override func(config *utils.Config)) int {
+	//	setup a socket and listen the port
+	config, err := utils.LoadConfig(configpath, override)
+	if err != nil {
+		utils.LogErr("Fatal error: ", err.Error())
+		return exitFatal
+	}
+	configmap := config.Map()
+	reloadable, err := readReloadable(configmap)
+	if err != nil {
+		utils.LogErr("Fatal error: ", err.Error())
+		return exitFatal
+	}
+	if err = reloadable.apply(); err != nil {
+		utils.LogErr("Fatal error: ", err.Error())
+		return exitFatal
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	if err = utils.ConfigureTracing(utils.GetSection("tracing", configmap)); err != nil {
+		utils.LogErr("Fatal error: ", err.Error())
+		return exitFatal
+	}
+	if config.HTTPHost != "" {
+		go startHTTPServer(config)
+	}
+	drainTimeout := time.Duration(config.DrainTimeout)
+	auth, err := handler.NewAuthenticator(utils.GetSection("auth", configmap))
+	if err != nil {
+		utils.LogErr("Fatal error: ", err.Error())
+		return exitFatal
+	}
+	audit, err := handler.NewAuditLog(utils.GetSection("audit", configmap))
+	if err != nil {
+		utils.LogErr("Fatal error: ", err.Error())
+		return exitFatal
 	}
+	if audit != nil {
+		handler.Use(audit.Middleware)
+		utils.Log("Auditing the chain operations to", audit.Path)
+	}
+	// the acl and the limits in use are swapped by the config reload
+	handler.Use(handler.ACLMiddleware)
+	fieldCipher, err := handler.NewFieldCipher(utils.GetSection("encryption", configmap))
+	if err != nil {
+		utils.LogErr("Fatal error: ", err.Error())
+		return exitFatal
+	}
+	if fieldCipher != nil {
+		handler.UseFieldCipher(fieldCipher)
+		utils.Log("Encrypting the fields of", len(fieldCipher.Fields), "record kinds with master key", fieldCipher.KeyID)
+	}
+	keyring, err := handler.NewKeyring(utils.GetSection("signing", configmap))
+	if err != nil {
+		utils.LogErr("Fatal error: ", err.Error())
+		return exitFatal
+	}
+	if keyring != nil {
+		handler.UseKeyring(keyring)
+		utils.Log("Signing writes with key", keyring.KeyID, keyring.Alg)
+	}
+	handler.Use(handler.LimiterMiddleware)
+	if config.MetricsHost != "" {
+		go startMetricsServer(config.MetricsHost)
+	}
+	listeners, err := openListeners(config, auth)
+	if err != nil {
+		utils.LogErr("Fatal error: ", err.Error())
+		return exitFatal
+	}
+	var admin *handler.AdminServer
+	if config.AdminHost != "" || hasAdminListener(listeners) {
+		admin = handler.NewAdminServer()
+		admin.UseAudit(audit)
+	}
+	if config.AdminHost != "" {
+		go startAdminServer(config.AdminHost, admin)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	utils.Log("Waiting for clients")
+	if admin != nil {
+		admin.SetReady(true)
+	}
+	for _, l := range listeners {
+		go l.serve(admin)
+	}
+	go watchConfig(configpath, override, configmap, hangup)
+
+	sig := <-stop
+	utils.Log("received", sig, "shutting down, draining the requests for up to", drainTimeout)
+	go func() {
+		<-stop
+		utils.LogErr("received", sig, "again, exiting without draining")
+		os.Exit(exitDrainTimeout)
+	}()
+	return shutdown(listeners, admin, audit, drainTimeout)
 
 	// you can run this part of code in Window System
 
@@ -53,33 +147,149 @@ func startServer(configpath string) {
 	//}
 }
 
+//hasAdminListener tells whether one of the listeners serves the admin interface.
+func hasAdminListener(listeners []*listener) bool {
+	for _, l := range listeners {
+		if l.config.Protocol == utils.ProtocolAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+//shutdown is to stop accepting, let the requests in flight finish within drainTimeout, tell the app clients
+//the proxy goes away and write out what is buffered. It returns the exit status.
+//The admin listeners are closed last, so the operators can follow the drain.
+func shutdown(listeners []*listener, admin *handler.AdminServer, audit *handler.AuditLog, drainTimeout time.Duration) int {
+	if admin != nil {
+		admin.SetReady(false)
+	}
+	closeListeners(listeners, utils.ProtocolFramed)
+	closeListeners(listeners, utils.ProtocolWebSocket)
+	handler.BeginShutdown()
+	utils.Log("notified", handler.NotifyGoingAway(drainTimeout), "connections of the shutdown")
+
+	status := exitOK
+	if !handler.Drain(drainTimeout) {
+		utils.LogErr("drain timeout, closing the connections with requests in flight")
+		status = exitDrainTimeout
+	}
+	handler.CloseConnections()
+	if audit != nil {
+		// the requests still waiting for the chain are audited when it answers, the connection closed or not
+		if status == exitDrainTimeout && !handler.Drain(auditGrace) {
+			utils.LogErr("xxx requests still in flight after", auditGrace, "more, closing the audit log without them")
+		}
+		if err := audit.Close(); err != nil {
+			utils.LogErr("xxx closing the audit log:", err)
+			status = exitFatal
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := utils.ShutdownTracing(ctx); err != nil {
+		utils.LogErr("xxx exporting the last spans:", err)
+	}
+	closeListeners(listeners, utils.ProtocolAdmin)
+	utils.Log("shutdown complete, exit status", status)
+	utils.CloseLogs()
+	return status
+}
+
+//startHTTPServer is to serve the provenance lookup for the customers.
+func startHTTPServer(config *utils.Config) {
+	publicurl := config.PublicURL
+	if publicurl == "" {
+		publicurl = "http://" + config.HTTPHost
+	}
+	utils.Log("Serving provenance lookup on", config.HTTPHost)
+	ps := handler.NewProvenanceServer(config.Channel, publicurl)
+	ps.ReportFont = config.ReportFont
+	err := http.ListenAndServe(config.HTTPHost, ps)
+	utils.CheckError(err)
+}
+
+//startAdminServer is to serve the admin interface for the operators.
+func startAdminServer(adminhost string, admin *handler.AdminServer) {
+	utils.Log("Serving admin interface on", adminhost)
+	err := http.ListenAndServe(adminhost, admin)
+	utils.CheckError(err)
+}
+
+//startMetricsServer is to serve the Prometheus metrics on /metrics.
+func startMetricsServer(metricshost string) {
+	utils.Log("Serving metrics on", metricshost)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", utils.MetricsHandler())
+	err := http.ListenAndServe(metricshost, mux)
+	utils.CheckError(err)
+}
+
 //handle the connection
-func handleConnection(conn net.Conn, timeout int) {
+func handleConnection(conn net.Conn, lc utils.ListenerConfig, auth *handler.Authenticator) {
 	tmpBuffer := make([]byte, 0)
 
 	buffer := make([]byte, 1024)
-	messnager := make(chan byte)
 	defer conn.Close()
+	defer utils.ConnectionOpened()()
+	tracked := handler.TrackConnection(conn, lc.Name)
+	defer tracked.Untrack()
+	log := utils.With(utils.F("remote", tracked.RemoteAddr), utils.F("listener", lc.Name))
+	//an unauthenticated connection is closed after the auth timeout
+	session := auth.NewSession(conn)
+	//and an app client which sends nothing for beatinginterval after it
+	interval := time.Duration(lc.BeatingInterval) * time.Second
+	if auth.Required {
+		conn.SetReadDeadline(time.Now().Add(auth.Timeout))
+	} else {
+		conn.SetReadDeadline(time.Now().Add(interval))
+	}
 	for {
 		n, err := conn.Read(buffer)
 		if err != nil {
-			utils.Log(conn.RemoteAddr().String(), " connection error: ", err)
+			if !session.Authenticated() && auth.Required {
+				log.Warn("not authenticated in time:", err)
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				utils.HeartbeatTimedOut()
+			}
+			log.Info("connection closed:", err)
 			return
 		}
 
-		tmpBuffer = utils.Depack(append(tmpBuffer, buffer[:n]...))
-		utils.Log("receive data string:", string(tmpBuffer))
-		handler.TaskDeliver(tmpBuffer, conn)
-		//start heartbeating
-		go utils.HeartBeating(conn, messnager, timeout)
-		//check if get message from client
-		go utils.GravelChannel(tmpBuffer, messnager)
+		receivedAt := time.Now()
+		tracked.Touch()
+		var messages [][]byte
+		messages, tmpBuffer, err = utils.Unpack(append(tmpBuffer, buffer[:n]...), lc.MaxFrameSize)
+		if err != nil {
+			// the frames before the wrong one are dropped too, the client is out of sync
+			log.Warn("xxx closing the connection:", err)
+			return
+		}
+		utils.FramesReceived(len(messages))
+		for _, message := range messages {
+			log.Debug("receive data string:", string(message))
+			handled, closeConn := session.Handle(conn, message)
+			if closeConn {
+				return
+			}
+			tracked.SetPrincipal(handler.PrincipalFromContext(session.Context()))
+			if handled {
+				continue
+			}
+			ctx := utils.ContextWithReceivedAt(utils.ContextWithLogger(session.Context(), log), receivedAt)
+			handler.TaskDeliver(ctx, message, conn)
+		}
+		//what the client sends is its heartbeat, it gives no more time to authenticate
+		if session.Authenticated() || !auth.Required {
+			conn.SetReadDeadline(time.Now().Add(interval))
+		}
 	}
 }
 
 // main function
 func main() {
-	runtime.GOMAXPROCS(runtime.NumCPU()*3/2 + 1)
 	//utils.LOG.Info("BlockChain Proxy Version: 1.0.0.0 - build-2018-04-07 12:01:00")
-	startServer("./conf/config.yaml")
+	os.Exit(run(os.Args[1:]))
 }
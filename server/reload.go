@@ -0,0 +1,177 @@
+package main
+
+import (
+	"goproxy4blockchain/handler"
+	"goproxy4blockchain/utils"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* The config is reloaded when config.yaml changes or on SIGHUP, without closing the connections of the app clients.
+   Reloaded: acl, limits, logging, redaction and upstream; the next request uses them,
+   the requests in flight finish as they started. The limiter is kept when the limits section is the same, a new one
+   starts with full buckets and no requests in flight.
+   The other keys are read at startup only, a reload changing one of them is rejected as a whole and the running config is kept.
+   The routes are defined in the code, there is nothing to reload for them.
+   配置热加载：文件变化或SIGHUP时校验并原子替换可热加载的配置，修改监听地址等需要重启的配置会被拒绝。
+*/
+
+//configPollInterval is how often the modification time of the config file is checked.
+const configPollInterval = 2 * time.Second
+
+//restartKeys are the config keys read at startup only.
+var restartKeys = []string{
+	"host", "tls", "auth", "audit", "encryption", "signing", "tracing",
+	"listeners", "httphost", "publicurl", "channel", "reportfont", "adminhost", "metricshost",
+	"beatinginterval", "draintimeout", "maxframesize",
+}
+
+//reloadableConfig is the part of the config applied at startup and again on each reload.
+type reloadableConfig struct {
+	logging  map[interface{}]interface{}
+	redactor *utils.Redactor
+	acl      *handler.ACL
+	limiter  *handler.Limiter
+	upstream string
+	apiKey   string
+}
+
+//readReloadable is to check the reloadable sections of configmap, nothing is applied yet.
+func readReloadable(configmap map[interface{}]interface{}) (*reloadableConfig, error) {
+	var err error
+	rc := &reloadableConfig{
+		logging:  utils.GetSection("logging", configmap),
+		upstream: utils.GetElement("upstream", configmap),
+	}
+	rc.apiKey, _ = configmap["upstreamapikey"].(string)
+	if rc.redactor, err = utils.NewRedactor(utils.GetSection("redaction", configmap)); err != nil {
+		return nil, err
+	}
+	if rc.acl, err = handler.NewACL(utils.GetSection("acl", configmap)); err != nil {
+		return nil, err
+	}
+	if rc.limiter, err = handler.NewLimiter(utils.GetSection("limits", configmap)); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+//apply is to use the config from the next request on; when logging is wrong nothing changes.
+func (rc *reloadableConfig) apply() error {
+	if err := utils.ConfigureLogging(rc.logging); err != nil {
+		return err
+	}
+	utils.SetRedactor(rc.redactor)
+	handler.UseACL(rc.acl)
+	handler.UseLimiter(rc.limiter)
+	handler.SetUpstream(rc.upstream, rc.apiKey)
+
+	if rc.redactor.Disabled {
+		utils.LogErr("UNSAFE: log redaction is disabled, secrets are written to the logs")
+	}
+	if rc.acl != nil {
+		utils.Log("ACL enabled with", len(rc.acl.Rules), "rules")
+	} else {
+		utils.Log("ACL disabled, every request is allowed")
+	}
+	if rc.limiter != nil {
+		utils.Log("Rate limits enabled")
+	}
+	if rc.apiKey == "" {
+		utils.Log("Sending the requests to", rc.upstream, "without api key")
+	} else {
+		utils.Log("Sending the requests to", rc.upstream)
+	}
+	return nil
+}
+
+//changedRestartKeys are the keys read at startup only which differ between the two configs.
+func changedRestartKeys(running map[interface{}]interface{}, next map[interface{}]interface{}) []string {
+	var changed []string
+	for _, key := range restartKeys {
+		if !reflect.DeepEqual(running[key], next[key]) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+//configWatcher reloads the config when the file changes or on SIGHUP.
+type configWatcher struct {
+	path     string
+	override func(config *utils.Config)
+	running  map[interface{}]interface{}
+	modTime  time.Time
+	size     int64
+}
+
+//watchConfig is to reload the config at path, override is the command line flags and running is the map of the config
+//the proxy started with.
+func watchConfig(path string, override func(config *utils.Config), running map[interface{}]interface{}, hangup <-chan os.Signal) {
+	cw := &configWatcher{path: path, override: override, running: running}
+	cw.changed()
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hangup:
+			// logrotate sends SIGHUP too, after moving the log files
+			utils.ReopenLogs()
+			utils.Log("SIGHUP received, log files reopened, reloading", path)
+			cw.changed()
+			cw.reload()
+		case <-ticker.C:
+			if cw.changed() {
+				utils.Log(path, "changed, reloading")
+				cw.reload()
+			}
+		}
+	}
+}
+
+//changed tells whether the config file was modified since it was last checked.
+func (cw *configWatcher) changed() bool {
+	info, err := os.Stat(cw.path)
+	if err != nil {
+		// being replaced by an editor, it is checked again on the next tick
+		return false
+	}
+	if info.ModTime().Equal(cw.modTime) && info.Size() == cw.size {
+		return false
+	}
+	cw.modTime, cw.size = info.ModTime(), info.Size()
+	return true
+}
+
+//reload is to apply the config file if it is valid and changes only reloadable sections, and keep the running one otherwise.
+func (cw *configWatcher) reload() {
+	config, err := utils.LoadConfig(cw.path, cw.override)
+	if err != nil {
+		utils.LogErr("xxx config reload rejected, keeping the running config:", err)
+		return
+	}
+	next := config.Map()
+	if changed := changedRestartKeys(cw.running, next); len(changed) > 0 {
+		utils.LogErr("xxx config reload rejected, restart the proxy to change", strings.Join(changed, ", "),
+			"- nothing was applied, the running config is kept")
+		return
+	}
+	rc, err := readReloadable(next)
+	if err == nil {
+		if reflect.DeepEqual(cw.running["limits"], next["limits"]) {
+			// a new limiter would refill the buckets of the clients over their quota
+			rc.limiter = handler.CurrentLimiter()
+		}
+		err = rc.apply()
+	}
+	if err != nil {
+		utils.LogErr("xxx config reload rejected, keeping the running config:", err)
+		return
+	}
+	cw.running = next
+	utils.Log("config reloaded from", cw.path)
+}
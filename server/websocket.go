@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"goproxy4blockchain/handler"
+	"goproxy4blockchain/utils"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/* The browsers and mini programs, which can't open a tcp socket, connect on a websocket listener:
+   listeners:
+     - name: web
+       address: 0.0.0.0:10443
+       protocol: websocket
+       path: /ws
+       origins: [https://app.example.com]
+       tls: {certfile: ./conf/server.crt, keyfile: ./conf/server.key}
+   Each text frame is a message of the framed protocol without testHeader and length: the auth frame, then the
+   requests, answered by a text frame each; a binary frame closes the connection.
+   The only push of the proxy is the server-going-away notification of the shutdown, sent as a text frame like on tcp;
+   the proxy has no subscriptions, the upstream is queried per request and sends no events to forward.
+   The proxy pings every half beatinginterval, the connection is closed when nothing, pong included, comes in a
+   beatinginterval; the pings of the client are answered and count as heartbeats too.
+   websocket接入：浏览器和小程序通过websocket收发与TCP相同的JSON消息，复用路由、认证和ACL，ping/pong即心跳。
+*/
+
+//wsControlTimeout is the time to write a ping, pong or close frame.
+const wsControlTimeout = 5 * time.Second
+
+//wsConn is a websocket as the net.Conn of the handlers: each Write is a text frame.
+type wsConn struct {
+	ws    *websocket.Conn
+	state *tls.ConnectionState
+	// writing serializes the frames, the notices of shutdown are written besides the responses
+	writing   sync.Mutex
+	reader    io.Reader
+	closeOnce sync.Once
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, reader, err := c.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.reader = reader
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+	if err := c.ws.WriteMessage(websocket.TextMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//Close is to send the close frame, best effort, and close the connection.
+func (c *wsConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		c.ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+		err = c.ws.Close()
+	})
+	return err
+}
+
+func (c *wsConn) LocalAddr() net.Addr  { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr { return c.ws.RemoteAddr() }
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+
+//ConnectionState is the TLS of the https connection, for the client certificate of mtls.
+func (c *wsConn) ConnectionState() tls.ConnectionState {
+	if c.state == nil {
+		return tls.ConnectionState{}
+	}
+	return *c.state
+}
+
+//webSocketHandler is the http handler of the websocket listener l.
+func webSocketHandler(l *listener) http.Handler {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin:     checkOrigin(l.config.Origins),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(l.config.Path, func(w http.ResponseWriter, r *http.Request) {
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+				defer func() { <-l.slots }()
+			default:
+				utils.With(utils.F("listener", l.config.Name)).Warn("xxx", l.config.MaxConnections, "connections open, refusing", r.RemoteAddr)
+				utils.ConnectionRejected(l.config.Name)
+				http.Error(w, "too many connections", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		// the upgrader has written the error response
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			utils.With(utils.F("listener", l.config.Name)).Warn("xxx websocket upgrade of", r.RemoteAddr, "failed:", err)
+			return
+		}
+		conn := &wsConn{ws: ws, state: r.TLS}
+		utils.Log(handler.RemoteAddr(conn), " websocket connect success on", l.config.Name)
+		handleWebSocket(conn, l.config, l.auth)
+	})
+	return mux
+}
+
+//checkOrigin allows the pages of origins, or of the host of the request without origins.
+func checkOrigin(origins []string) func(r *http.Request) bool {
+	if len(origins) == 0 {
+		// the same origin check of gorilla, the clients which aren't browsers send no origin
+		return nil
+	}
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || allowed["*"] {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return allowed[strings.ToLower(u.Scheme+"://"+u.Host)]
+	}
+}
+
+//handleWebSocket is handleConnection for a websocket: a text frame is a message, the pongs are the heartbeats.
+func handleWebSocket(conn *wsConn, lc utils.ListenerConfig, auth *handler.Authenticator) {
+	defer conn.Close()
+	defer utils.ConnectionOpened()()
+	tracked := handler.TrackConnection(conn, lc.Name)
+	defer tracked.Untrack()
+	log := utils.With(utils.F("remote", tracked.RemoteAddr), utils.F("listener", lc.Name))
+	//an unauthenticated connection is closed after the auth timeout
+	session := auth.NewSession(conn)
+	interval := time.Duration(lc.BeatingInterval) * time.Second
+	// the pongs of a client which isn't authenticated don't give it more time
+	authDeadline := time.Now().Add(auth.Timeout)
+	deadline := func() time.Time {
+		if auth.Required && !session.Authenticated() {
+			return authDeadline
+		}
+		return time.Now().Add(interval)
+	}
+	heartbeat := func() {
+		tracked.Touch()
+		conn.SetReadDeadline(deadline())
+	}
+
+	conn.ws.SetReadLimit(int64(lc.MaxFrameSize))
+	conn.SetReadDeadline(deadline())
+	conn.ws.SetPongHandler(func(string) error {
+		heartbeat()
+		return nil
+	})
+	conn.ws.SetPingHandler(func(data string) error {
+		heartbeat()
+		err := conn.ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(wsControlTimeout))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	go pingWebSocket(conn, interval/2, stop)
+
+	for {
+		messageType, message, err := conn.ws.ReadMessage()
+		if err != nil {
+			if !session.Authenticated() && auth.Required {
+				log.Warn("not authenticated in time:", err)
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				utils.HeartbeatTimedOut()
+			}
+			log.Info("connection closed:", err)
+			return
+		}
+		if messageType != websocket.TextMessage {
+			log.Warn("xxx binary frame received, the messages are text frames, closing")
+			conn.ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "text frames only"), time.Now().Add(wsControlTimeout))
+			return
+		}
+
+		receivedAt := time.Now()
+		heartbeat()
+		utils.FramesReceived(1)
+		log.Debug("receive data string:", string(message))
+		handled, closeConn := session.Handle(conn, message)
+		if closeConn {
+			return
+		}
+		tracked.SetPrincipal(handler.PrincipalFromContext(session.Context()))
+		if handled {
+			conn.SetReadDeadline(deadline())
+			continue
+		}
+		ctx := utils.ContextWithReceivedAt(utils.ContextWithLogger(session.Context(), log), receivedAt)
+		handler.TaskDeliver(ctx, message, conn)
+		//the upstream may have answered after the heartbeat
+		conn.SetReadDeadline(deadline())
+	}
+}
+
+//pingWebSocket is to ping the client every interval until stop is closed.
+func pingWebSocket(conn *wsConn, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := conn.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsControlTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+/* Authentication of app clients on the framed protocol, the first frames of a connection:
+   api key:      {"content":{"method":"auth","params":{"clientId":"app-1","apiKey":"..."}}}
+   hmac:         {"content":{"method":"auth-challenge","params":{"clientId":"app-1"}}}  -> {"result":{"nonce":"..."}}
+                 {"content":{"method":"auth","params":{"clientId":"app-1","nonce":"...","signature":"..."}}}
+   the signature is hex(HMAC-SHA256(secret, nonce + ":" + clientId)).
+
+   客户端认证：连接建立后第一帧须为认证消息，支持API key和基于共享密钥的HMAC挑战应答。
+*/
+
+//auth methods of the first frames.
+const (
+	AuthMethod          = "auth"
+	AuthChallengeMethod = "auth-challenge"
+)
+
+//AuthSignature is the answer to a challenge.
+func AuthSignature(secret string, nonce string, clientID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(nonce + ":" + clientID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//Authenticate is the client side of the handshake, with an api key or else with the shared secret.
+func Authenticate(conn net.Conn, clientID string, apiKey string, secret string) error {
+	decoder := json.NewDecoder(conn)
+	params := map[string]interface{}{"clientId": clientID}
+	if apiKey != "" {
+		params["apiKey"] = apiKey
+	} else {
+		result, err := authCall(conn, decoder, AuthChallengeMethod, params)
+		if err != nil {
+			return err
+		}
+		nonce, _ := result["nonce"].(string)
+		params["nonce"] = nonce
+		params["signature"] = AuthSignature(secret, nonce, clientID)
+	}
+	_, err := authCall(conn, decoder, AuthMethod, params)
+	return err
+}
+
+func authCall(conn net.Conn, decoder *json.Decoder, method string, params map[string]interface{}) (map[string]interface{}, error) {
+	frame, err := json.Marshal(map[string]interface{}{
+		"meta":    map[string]interface{}{},
+		"content": map[string]interface{}{"method": method, "params": params, "id": 0, "jsonrpc": "2.0"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(Enpack(frame)); err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Result map[string]interface{} `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err = decoder.Decode(&reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != nil {
+		return nil, fmt.Errorf("%s: %d:%s", method, reply.Error.Code, reply.Error.Message)
+	}
+	return reply.Result, nil
+}
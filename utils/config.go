@@ -0,0 +1,708 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+/* The config of the proxy, read from config.yaml, or the json, toml or xml file of readconfig.go, into Config:
+   the unknown keys are errors, the missing ones get the defaults of DefaultConfig, then the PROXY_* environment
+   variables override the file, and every wrong field is reported at once.
+   The variable of a key is its path in upper case, joined by "_", with "-" as "_"; its value is yaml:
+     PROXY_HOST=0.0.0.0:10399  PROXY_LOGGING_LEVEL=debug  PROXY_ACL_RULES='[{principals: [app-1], methods: [source-state]}]'
+   The routes are defined in the code, there is no config for them.
+   类型化配置：默认值、未知字段检测、逐字段校验，以及PROXY_*环境变量覆盖。
+*/
+
+//EnvPrefix starts the names of the environment variables overriding the config.
+const EnvPrefix = "PROXY_"
+
+//DefaultMaxFrameSize is the largest message of the app clients when maxframesize isn't set, 4 MiB.
+const DefaultMaxFrameSize = 4 << 20
+
+//DefaultUpstream is the JSON-RPC endpoint of the block chain service when upstream isn't set.
+const DefaultUpstream = "https://www.ninechain.net/api/v2"
+
+//Config is the config of the proxy; the sections are nil when they are not in the file.
+type Config struct {
+	// server
+	Host            string   `yaml:"host"`
+	BeatingInterval int      `yaml:"beatinginterval"`
+	DrainTimeout    Duration `yaml:"draintimeout"`
+	// MaxFrameSize is the largest message of the app clients in bytes, a longer frame closes the connection.
+	MaxFrameSize int    `yaml:"maxframesize"`
+	Channel      string `yaml:"channel,omitempty"`
+	HTTPHost     string `yaml:"httphost,omitempty"`
+	PublicURL    string `yaml:"publicurl,omitempty"`
+	ReportFont   string `yaml:"reportfont,omitempty"`
+	AdminHost    string `yaml:"adminhost,omitempty"`
+	MetricsHost  string `yaml:"metricshost,omitempty"`
+	// Listeners replace host and tls when they are set.
+	Listeners []ListenerConfig `yaml:"listeners,omitempty"`
+
+	// upstream
+	Upstream string `yaml:"upstream"`
+	// UpstreamAPIKey is sent to the upstream as X-Api-Key, it is read from PROXY_UPSTREAMAPIKEY only.
+	UpstreamAPIKey string `yaml:"upstreamapikey,omitempty"`
+	// UpstreamInsecure allows an http upstream, the api key and the records then go in cleartext.
+	UpstreamInsecure bool `yaml:"upstreaminsecure,omitempty"`
+
+	// security
+	TLS        *TLSConfig        `yaml:"tls,omitempty"`
+	Auth       *AuthConfig       `yaml:"auth,omitempty"`
+	ACL        *ACLConfig        `yaml:"acl,omitempty"`
+	Limits     *LimitsConfig     `yaml:"limits,omitempty"`
+	Signing    *SigningConfig    `yaml:"signing,omitempty"`
+	Encryption *EncryptionConfig `yaml:"encryption,omitempty"`
+	Redaction  *RedactionConfig  `yaml:"redaction,omitempty"`
+	Audit      *AuditConfig      `yaml:"audit,omitempty"`
+
+	// logging and observability
+	Logging *LoggingConfig `yaml:"logging,omitempty"`
+	Tracing *TracingConfig `yaml:"tracing,omitempty"`
+}
+
+//ListenerConfig is a listener of the proxy, see Config.EffectiveListeners.
+type ListenerConfig struct {
+	Name string `yaml:"name"`
+	// Network is tcp or unix, tcp when it is not set.
+	Network string `yaml:"network,omitempty"`
+	// Address is host:port, or the path of the unix socket.
+	Address string `yaml:"address"`
+	// Protocol is framed for the app clients, websocket for the app clients in a browser, or admin for the admin interface;
+	// framed when it is not set.
+	Protocol string `yaml:"protocol,omitempty"`
+	// Path is the URL path of the websocket endpoint, /ws when it is not set.
+	Path string `yaml:"path,omitempty"`
+	// Origins are the web pages allowed to open a websocket, "*" for any; without them only the pages of the proxy host.
+	Origins []string `yaml:"origins,omitempty"`
+	// Mode is the permissions of the unix socket, e.g. "0660".
+	Mode string     `yaml:"mode,omitempty"`
+	TLS  *TLSConfig `yaml:"tls,omitempty"`
+	// Auth changes the auth section for the connections of the listener, the clients are the same.
+	Auth *ListenerAuthConfig `yaml:"auth,omitempty"`
+	// MaxConnections is the number of connections open at once, 0 means no limit.
+	MaxConnections int `yaml:"maxconnections,omitempty"`
+	// BeatingInterval is the one of the config when it is 0.
+	BeatingInterval int `yaml:"beatinginterval,omitempty"`
+	// MaxFrameSize is the one of the config when it is 0.
+	MaxFrameSize int `yaml:"maxframesize,omitempty"`
+}
+
+//ListenerAuthConfig is the authentication on a listener, what isn't set is taken from the auth section.
+type ListenerAuthConfig struct {
+	Required *bool `yaml:"required,omitempty"`
+	Timeout  int   `yaml:"timeout,omitempty"`
+	MTLS     *bool `yaml:"mtls,omitempty"`
+}
+
+//listener protocols.
+const (
+	ProtocolFramed    = "framed"
+	ProtocolWebSocket = "websocket"
+	ProtocolAdmin     = "admin"
+)
+
+//DefaultWebSocketPath is the path of a websocket listener without path.
+const DefaultWebSocketPath = "/ws"
+
+//EffectiveListeners are the listeners of the config, with the defaults set;
+//without listeners it is the one of host and tls, named default.
+func (c *Config) EffectiveListeners() []ListenerConfig {
+	if len(c.Listeners) == 0 {
+		return []ListenerConfig{{Name: "default", Network: "tcp", Address: c.Host, Protocol: ProtocolFramed, TLS: c.TLS,
+			BeatingInterval: c.BeatingInterval, MaxFrameSize: c.MaxFrameSize}}
+	}
+	listeners := make([]ListenerConfig, len(c.Listeners))
+	for i, l := range c.Listeners {
+		if l.Network == "" {
+			l.Network = "tcp"
+		}
+		if l.Protocol == "" {
+			l.Protocol = ProtocolFramed
+		}
+		if l.Protocol == ProtocolWebSocket && l.Path == "" {
+			l.Path = DefaultWebSocketPath
+		}
+		if l.BeatingInterval == 0 {
+			l.BeatingInterval = c.BeatingInterval
+		}
+		if l.MaxFrameSize == 0 {
+			l.MaxFrameSize = c.MaxFrameSize
+		}
+		listeners[i] = l
+	}
+	return listeners
+}
+
+//TLSConfig is the tls section, the TLS of the client listener.
+type TLSConfig struct {
+	CertFile     string `yaml:"certfile"`
+	KeyFile      string `yaml:"keyfile"`
+	ClientCAFile string `yaml:"clientcafile,omitempty"`
+	ClientAuth   string `yaml:"clientauth,omitempty"`
+}
+
+//AuthConfig is the auth section, the authentication of the app clients.
+type AuthConfig struct {
+	Required bool `yaml:"required,omitempty"`
+	// Timeout in seconds, 5 when it is 0.
+	Timeout int                   `yaml:"timeout,omitempty"`
+	MTLS    bool                  `yaml:"mtls,omitempty"`
+	Clients map[string]AuthClient `yaml:"clients,omitempty"`
+}
+
+//AuthClient is the credential of an app client, an api key or the secret of the HMAC challenge.
+type AuthClient struct {
+	APIKey string   `yaml:"apikey,omitempty"`
+	Secret string   `yaml:"secret,omitempty"`
+	Groups []string `yaml:"groups,omitempty"`
+}
+
+//ACLConfig is the acl section, a request matching no rule is denied.
+type ACLConfig struct {
+	Rules []ACLRuleConfig `yaml:"rules"`
+}
+
+//ACLRuleConfig is a rule of the acl, an empty list matches everything.
+type ACLRuleConfig struct {
+	Principals  StringList `yaml:"principals,omitempty"`
+	Groups      StringList `yaml:"groups,omitempty"`
+	Channels    StringList `yaml:"channels,omitempty"`
+	KeyPrefixes StringList `yaml:"keyprefixes,omitempty"`
+	Methods     StringList `yaml:"methods,omitempty"`
+	Effect      string     `yaml:"effect,omitempty"`
+}
+
+//LimitsConfig is the limits section, the quotas of the requests sent to the block chain service.
+type LimitsConfig struct {
+	Global     *RateLimit           `yaml:"global,omitempty"`
+	Principal  *RateLimit           `yaml:"principal,omitempty"`
+	Channel    *RateLimit           `yaml:"channel,omitempty"`
+	Principals map[string]RateLimit `yaml:"principals,omitempty"`
+	Channels   map[string]RateLimit `yaml:"channels,omitempty"`
+}
+
+//RateLimit is a quota, rate per second; 0 means no limit.
+type RateLimit struct {
+	Rate     float64 `yaml:"rate,omitempty"`
+	Burst    int     `yaml:"burst,omitempty"`
+	Inflight int     `yaml:"inflight,omitempty"`
+}
+
+//SigningConfig is the signing section, the key signing the writes and the keys verifying the records.
+type SigningConfig struct {
+	KeyStore    string      `yaml:"keystore,omitempty"`
+	KeyID       string      `yaml:"keyid,omitempty"`
+	TrustedKeys TrustedKeys `yaml:"trustedkeys,omitempty"`
+}
+
+//EncryptionConfig is the encryption section, the fields encrypted by record kind.
+type EncryptionConfig struct {
+	MasterKey string                `yaml:"masterkey"`
+	KeyID     string                `yaml:"keyid,omitempty"`
+	Fields    map[string]StringList `yaml:"fields,omitempty"`
+}
+
+//RedactionConfig is the redaction section, adding to the secrets masked in the logs.
+type RedactionConfig struct {
+	Headers     []string `yaml:"headers,omitempty"`
+	Fields      []string `yaml:"fields,omitempty"`
+	AllowFields []string `yaml:"allowfields,omitempty"`
+	// Entropy is true when it is not set.
+	Entropy       *bool `yaml:"entropy,omitempty"`
+	UnsafeDisable bool  `yaml:"unsafe-disable,omitempty"`
+}
+
+//AuditConfig is the audit section, the hash-chained log of the chain operations.
+type AuditConfig struct {
+	File string `yaml:"file"`
+	Sync bool   `yaml:"sync,omitempty"`
+}
+
+//LoggingConfig is the logging section.
+type LoggingConfig struct {
+	Level  string `yaml:"level,omitempty"`
+	Format string `yaml:"format,omitempty"`
+	// Stdout is true when it is not set.
+	Stdout    *bool          `yaml:"stdout,omitempty"`
+	File      *LogFileConfig `yaml:"file,omitempty"`
+	ErrorFile *LogFileConfig `yaml:"errorfile,omitempty"`
+}
+
+//LogFileConfig is a rotated log file, maxsize in MB.
+type LogFileConfig struct {
+	Path       string   `yaml:"path"`
+	MaxSize    int64    `yaml:"maxsize,omitempty"`
+	Rotate     Duration `yaml:"rotate,omitempty"`
+	Compress   bool     `yaml:"compress,omitempty"`
+	MaxBackups int      `yaml:"maxbackups,omitempty"`
+	MaxAge     Duration `yaml:"maxage,omitempty"`
+}
+
+//TracingConfig is the tracing section.
+type TracingConfig struct {
+	Exporter string `yaml:"exporter,omitempty"`
+	Endpoint string `yaml:"endpoint,omitempty"`
+	Insecure bool   `yaml:"insecure,omitempty"`
+	File     string `yaml:"file,omitempty"`
+	Service  string `yaml:"service,omitempty"`
+	// Ratio is 1 when it is not set.
+	Ratio *float64 `yaml:"ratio,omitempty"`
+}
+
+//Duration is a time.Duration written as "30s" or "24h".
+type Duration time.Duration
+
+//UnmarshalYAML is to read a duration.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration, e.g. 30s or 24h", s)
+	}
+	*d = Duration(value)
+	return nil
+}
+
+//MarshalYAML is to write the duration as text.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+//StringList is a yaml list of strings, or a single string.
+type StringList []string
+
+//UnmarshalYAML is to read a list or a single value.
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*l = list
+		return nil
+	}
+	var single string
+	if err := unmarshal(&single); err != nil {
+		return err
+	}
+	*l = StringList{single}
+	return nil
+}
+
+//TrustedKeys are the public key files, a list of files has the default key ids, a map gives the key id of each file.
+type TrustedKeys struct {
+	Files []string
+	ByID  map[string]string
+}
+
+//UnmarshalYAML is to read a list of files or a map of key ids to files.
+func (t *TrustedKeys) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var files []string
+	if err := unmarshal(&files); err == nil {
+		t.Files, t.ByID = files, nil
+		return nil
+	}
+	var byID map[string]string
+	if err := unmarshal(&byID); err != nil {
+		return fmt.Errorf("trustedkeys is a list of files, or a map of key ids to files")
+	}
+	t.Files, t.ByID = nil, byID
+	return nil
+}
+
+//MarshalYAML is to write the keys as they were read.
+func (t TrustedKeys) MarshalYAML() (interface{}, error) {
+	if t.ByID != nil {
+		return t.ByID, nil
+	}
+	return t.Files, nil
+}
+
+//DefaultConfig is the config with the defaults of the keys which have one.
+func DefaultConfig() *Config {
+	return &Config{
+		Host:            "localhost:10399",
+		BeatingInterval: 10,
+		DrainTimeout:    Duration(30 * time.Second),
+		MaxFrameSize:    DefaultMaxFrameSize,
+		Upstream:        DefaultUpstream,
+	}
+}
+
+var yamlLine = regexp.MustCompile(`line \d+: `)
+
+//LoadConfig is to read the config file at path, in any format of ConfigFormat, override it by the environment
+//and then by the overrides, e.g. the command line flags, and validate it.
+func LoadConfig(path string, overrides ...func(config *Config)) (*Config, error) {
+	data, format, err := ReadConfigYAML(path)
+	if err != nil {
+		return nil, err
+	}
+	config := DefaultConfig()
+	if err = yaml.UnmarshalStrict(data, config); err != nil {
+		if format != "yaml" {
+			// the lines are the ones of the yaml converted from the file
+			return nil, fmt.Errorf("%s: %s", path, yamlLine.ReplaceAllString(err.Error(), ""))
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if config.UpstreamAPIKey != "" {
+		// the config files end up in the repositories and the backups
+		return nil, fmt.Errorf("%s: upstreamapikey: set %sUPSTREAMAPIKEY in the environment instead of the file", path, EnvPrefix)
+	}
+	if err = config.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	for _, override := range overrides {
+		override(config)
+	}
+	if err = config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+//ApplyEnv is to override the config by the PROXY_* variables that lookup finds.
+func (c *Config) ApplyEnv(lookup func(name string) (string, bool)) error {
+	_, err := applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, lookup)
+	return err
+}
+
+//applyEnv is to override the fields of the struct v by the variables named prefix + key, it tells whether one was set.
+func applyEnv(v reflect.Value, prefix string, lookup func(name string) (string, bool)) (bool, error) {
+	applied := false
+	for i := 0; i < v.NumField(); i++ {
+		key := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" {
+			continue
+		}
+		name := prefix + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+		field := v.Field(i)
+
+		// a section is only created when one of its keys is set
+		if field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct {
+			section := reflect.New(field.Type().Elem())
+			if !field.IsNil() {
+				section.Elem().Set(field.Elem())
+			}
+			set, err := applyEnv(section.Elem(), name+"_", lookup)
+			if err != nil {
+				return applied, err
+			}
+			if set {
+				field.Set(section)
+				applied = true
+			}
+			continue
+		}
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if field.Kind() == reflect.String {
+			field.SetString(value)
+		} else if err := yaml.UnmarshalStrict([]byte(value), field.Addr().Interface()); err != nil {
+			return applied, fmt.Errorf("%s: %v", name, err)
+		}
+		applied = true
+	}
+	return applied, nil
+}
+
+//FieldError is a wrong value in the config.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+//ConfigErrors are all the wrong values of a config.
+type ConfigErrors []FieldError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, 0, len(errs)+1)
+	lines = append(lines, fmt.Sprintf("%d wrong values in the config", len(errs)))
+	for _, e := range errs {
+		lines = append(lines, "  "+e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+//Validate is to check the values of the config, the error is ConfigErrors listing every wrong field.
+func (c *Config) Validate() error {
+	var errs ConfigErrors
+	fail := func(field string, format string, v ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, v...)})
+	}
+	checkAddress := func(field string, address string, required bool) {
+		if address == "" {
+			if required {
+				fail(field, "is required")
+			}
+			return
+		}
+		if _, port, err := net.SplitHostPort(address); err != nil {
+			fail(field, "expect host:port, %v", err)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			fail(field, "port %q is not a number from 0 to 65535", port)
+		}
+	}
+	checkURL := func(field string, rawURL string) {
+		if endpoint, err := url.Parse(rawURL); err != nil {
+			fail(field, "%v", err)
+		} else if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			fail(field, "%q isn't an http or https url", rawURL)
+		}
+	}
+	checkRate := func(field string, limit *RateLimit) {
+		if limit != nil && (limit.Rate < 0 || limit.Burst < 0 || limit.Inflight < 0) {
+			fail(field, "rate, burst and inflight must not be negative")
+		}
+	}
+	checkTLS := func(field string, t *TLSConfig) {
+		if t == nil {
+			return
+		}
+		if t.CertFile == "" {
+			fail(field+".certfile", "is required")
+		}
+		if t.KeyFile == "" {
+			fail(field+".keyfile", "is required")
+		}
+		switch t.ClientAuth {
+		case "", "none", "request":
+		case "verify", "require":
+			if t.ClientCAFile == "" {
+				fail(field+".clientcafile", "is required by clientauth %s", t.ClientAuth)
+			}
+		default:
+			fail(field+".clientauth", "unknown %q, use none, request, verify or require", t.ClientAuth)
+		}
+	}
+	checkLogFile := func(field string, f *LogFileConfig) {
+		if f == nil {
+			return
+		}
+		if f.Path == "" {
+			fail(field+".path", "is required")
+		}
+		if f.MaxSize < 0 {
+			fail(field+".maxsize", "must not be negative")
+		}
+		if f.MaxBackups < 0 {
+			fail(field+".maxbackups", "must not be negative")
+		}
+		if f.Rotate < 0 {
+			fail(field+".rotate", "must not be negative")
+		}
+		if f.MaxAge < 0 {
+			fail(field+".maxage", "must not be negative")
+		}
+	}
+
+	checkAddress("host", c.Host, true)
+	if c.BeatingInterval <= 0 {
+		fail("beatinginterval", "must be a positive number of seconds, got %d", c.BeatingInterval)
+	}
+	if c.DrainTimeout < 0 {
+		fail("draintimeout", "must not be negative")
+	}
+	if c.MaxFrameSize <= 0 {
+		fail("maxframesize", "must be a positive number of bytes, got %d", c.MaxFrameSize)
+	}
+	checkAddress("httphost", c.HTTPHost, false)
+	checkAddress("adminhost", c.AdminHost, false)
+	if host, _, err := net.SplitHostPort(c.AdminHost); err == nil && !isLoopback(host) {
+		fail("adminhost", "the admin interface listens on a loopback address only, got %q", c.AdminHost)
+	}
+	checkAddress("metricshost", c.MetricsHost, false)
+	if c.PublicURL != "" {
+		checkURL("publicurl", c.PublicURL)
+	}
+	checkURL("upstream", c.Upstream)
+	if endpoint, err := url.Parse(c.Upstream); err == nil && endpoint.Scheme == "http" && !c.UpstreamInsecure {
+		fail("upstream", "%q is not https, the api key and the records would go in cleartext; set upstreaminsecure to allow it", c.Upstream)
+	}
+
+	checkTLS("tls", c.TLS)
+	names := make(map[string]bool)
+	for i, l := range c.Listeners {
+		field := fmt.Sprintf("listeners[%d]", i)
+		if l.Name == "" {
+			fail(field+".name", "is required")
+		} else if names[l.Name] {
+			fail(field+".name", "%q is the name of another listener", l.Name)
+		}
+		names[l.Name] = true
+		switch l.Network {
+		case "", "tcp":
+			checkAddress(field+".address", l.Address, true)
+			if host, _, err := net.SplitHostPort(l.Address); err == nil && l.Protocol == ProtocolAdmin && !isLoopback(host) {
+				fail(field+".address", "the admin interface listens on a loopback address or a unix socket only")
+			}
+		case "unix":
+			if l.Address == "" {
+				fail(field+".address", "is required, the path of the socket")
+			}
+			if l.TLS != nil {
+				fail(field+".tls", "is for tcp listeners")
+			}
+		default:
+			fail(field+".network", "unknown %q, use tcp or unix", l.Network)
+		}
+		if l.Mode != "" {
+			if mode, err := strconv.ParseUint(l.Mode, 8, 32); err != nil || mode > 0777 {
+				fail(field+".mode", "%q is not permissions in octal, e.g. 0660", l.Mode)
+			} else if l.Network != "unix" {
+				fail(field+".mode", "is for unix sockets")
+			}
+		}
+		switch l.Protocol {
+		case "", ProtocolFramed, ProtocolAdmin:
+			if l.Auth != nil && l.Protocol == ProtocolAdmin {
+				fail(field+".auth", "the admin interface has no authentication, keep it on a private socket")
+			}
+			if l.Path != "" || len(l.Origins) > 0 {
+				fail(field+".path", "path and origins are for websocket listeners")
+			}
+		case ProtocolWebSocket:
+			if l.Path != "" && !strings.HasPrefix(l.Path, "/") {
+				fail(field+".path", "%q doesn't start with /", l.Path)
+			}
+			for j, origin := range l.Origins {
+				if origin == "*" {
+					continue
+				}
+				if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+					fail(fmt.Sprintf("%s.origins[%d]", field, j), "%q is not an origin, e.g. https://app.example.com", origin)
+				}
+			}
+		default:
+			fail(field+".protocol", "unknown %q, use framed, websocket or admin", l.Protocol)
+		}
+		checkTLS(field+".tls", l.TLS)
+		if l.Auth != nil {
+			if l.Auth.Timeout < 0 {
+				fail(field+".auth.timeout", "must not be negative")
+			}
+			if l.Auth.MTLS != nil && *l.Auth.MTLS && l.TLS == nil {
+				fail(field+".auth.mtls", "needs tls")
+			}
+		}
+		if l.MaxConnections < 0 {
+			fail(field+".maxconnections", "must not be negative")
+		}
+		if l.BeatingInterval < 0 {
+			fail(field+".beatinginterval", "must not be negative")
+		}
+		if l.MaxFrameSize < 0 {
+			fail(field+".maxframesize", "must not be negative")
+		}
+	}
+	if a := c.Auth; a != nil {
+		if a.Timeout < 0 {
+			fail("auth.timeout", "must not be negative")
+		}
+		for id, client := range a.Clients {
+			if client.APIKey == "" && client.Secret == "" {
+				fail("auth.clients."+id, "expect apikey or secret")
+			}
+		}
+	}
+	if c.Auth != nil && c.ACL == nil {
+		fail("acl", "is required with auth, the requests are denied by default; "+
+			"rules: [{principals: [\"*\"]}] allows every authenticated client")
+	}
+	if c.ACL != nil {
+		for i, rule := range c.ACL.Rules {
+			if rule.Effect != "" && rule.Effect != "allow" && rule.Effect != "deny" {
+				fail(fmt.Sprintf("acl.rules[%d].effect", i), "unknown %q, use allow or deny", rule.Effect)
+			}
+		}
+	}
+	if l := c.Limits; l != nil {
+		checkRate("limits.global", l.Global)
+		checkRate("limits.principal", l.Principal)
+		checkRate("limits.channel", l.Channel)
+		for id, limit := range l.Principals {
+			limit := limit
+			checkRate("limits.principals."+id, &limit)
+		}
+		for id, limit := range l.Channels {
+			limit := limit
+			checkRate("limits.channels."+id, &limit)
+		}
+	}
+	if c.Encryption != nil && c.Encryption.MasterKey == "" {
+		fail("encryption.masterkey", "is required")
+	}
+	if c.Audit != nil && c.Audit.File == "" {
+		fail("audit.file", "is required")
+	}
+	if l := c.Logging; l != nil {
+		if l.Level != "" {
+			if _, err := ParseLevel(l.Level); err != nil {
+				fail("logging.level", "%v", err)
+			}
+		}
+		if l.Format != "" && l.Format != FormatConsole && l.Format != FormatJSON {
+			fail("logging.format", "unknown %q, use console or json", l.Format)
+		}
+		checkLogFile("logging.file", l.File)
+		checkLogFile("logging.errorfile", l.ErrorFile)
+	}
+	if t := c.Tracing; t != nil {
+		switch t.Exporter {
+		case "", "otlp":
+		case "file":
+			if t.File == "" {
+				fail("tracing.file", "is required by the file exporter")
+			}
+		default:
+			fail("tracing.exporter", "unknown %q, use otlp or file", t.Exporter)
+		}
+		if t.Ratio != nil && (*t.Ratio < 0 || *t.Ratio > 1) {
+			fail("tracing.ratio", "is a number from 0 to 1")
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//isLoopback tells whether host is localhost or a loopback ip.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+//Map is the config as the map of GetSection and GetElement, the sections not set are left out.
+func (c *Config) Map() map[interface{}]interface{} {
+	m := make(map[interface{}]interface{})
+	data, err := yaml.Marshal(c)
+	if err == nil {
+		err = yaml.Unmarshal(data, &m)
+	}
+	if err != nil {
+		LogErr("xxx Config.Map()", err)
+	}
+	return m
+}
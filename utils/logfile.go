@@ -0,0 +1,316 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Log files, in the logging section of config.yaml next to level and format:
+   logging:
+     stdout: true                 # keep writing to stdout as well, true by default
+     file:                        # the access log, every line at the level
+       path: ./log/davinci.log
+       maxsize: 100               # MB before the file is rotated, 0 for no limit
+       rotate: 24h                # rotate every interval too, aligned on the interval in UTC
+       compress: true             # gzip the rotated files
+       maxbackups: 7              # rotated files kept, 0 keeps all
+       maxage: 720h               # rotated files older than this are removed, 0 keeps all
+     errorfile:                   # the error log, warn and error lines only; same keys as file
+       path: ./log/error.log
+   A SIGHUP reopens the files, for an external logrotate moving them away.
+   日志文件：支持按大小和时间轮转、gzip压缩、保留数量和期限，收到SIGHUP时重新打开文件。
+*/
+
+//RotatingFile is a log file rotated by size and time, safe for concurrent use.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	Interval   time.Duration
+	Compress   bool
+	MaxBackups int
+	MaxAge     time.Duration
+
+	mu         sync.Mutex
+	file       *os.File
+	size       int64
+	nextRotate time.Time
+	// cleanMu keeps the compression and the removal of the backups in order.
+	cleanMu sync.Mutex
+}
+
+var (
+	logFilesMu sync.Mutex
+	logFiles   []*RotatingFile
+)
+
+//NewRotatingFile is to read a file section of the logging config.
+func NewRotatingFile(section map[interface{}]interface{}) (*RotatingFile, error) {
+	path := GetElement("path", section)
+	if path == "" {
+		return nil, fmt.Errorf("log file needs path")
+	}
+	f := &RotatingFile{Path: path, Compress: fmt.Sprint(section["compress"]) == "true"}
+	if _, ok := section["maxsize"]; ok {
+		mb, err := strconv.ParseInt(GetElement("maxsize", section), 10, 64)
+		if err != nil || mb < 0 {
+			return nil, fmt.Errorf("log file %s: maxsize is a number of MB", path)
+		}
+		f.MaxSize = mb << 20
+	}
+	if _, ok := section["maxbackups"]; ok {
+		n, err := strconv.Atoi(GetElement("maxbackups", section))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("log file %s: maxbackups is a number of files", path)
+		}
+		f.MaxBackups = n
+	}
+	for key, d := range map[string]*time.Duration{"rotate": &f.Interval, "maxage": &f.MaxAge} {
+		if _, ok := section[key]; !ok {
+			continue
+		}
+		value, err := time.ParseDuration(GetElement(key, section))
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("log file %s: %s is a duration, e.g. 24h", path, key)
+		}
+		*d = value
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+//open is to open the file for appending, f.mu is held.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	if f.Interval > 0 {
+		// a file left from an earlier period is rotated at the first write
+		started := time.Now()
+		if f.size > 0 {
+			started = info.ModTime()
+		}
+		f.nextRotate = started.Truncate(f.Interval).Add(f.Interval)
+	}
+	return nil
+}
+
+//Write is to append p to the file, rotating it first when it is full or its period is over.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	full := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	expired := f.Interval > 0 && !time.Now().Before(f.nextRotate)
+	if full || expired {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+//rotate is to move the file to a backup named by the time and open a new one, f.mu is held.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	ext := filepath.Ext(f.Path)
+	base := strings.TrimSuffix(f.Path, ext) + "-" + time.Now().Format("20060102T150405")
+	backup := base + ext
+	for i := 1; fileExists(backup) || fileExists(backup+".gz"); i++ {
+		backup = base + "." + strconv.Itoa(i) + ext
+	}
+	if err := os.Rename(f.Path, backup); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	go f.cleanup(backup)
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+//cleanup is to compress the new backup and remove the backups beyond the retention.
+func (f *RotatingFile) cleanup(backup string) {
+	f.cleanMu.Lock()
+	defer f.cleanMu.Unlock()
+	if f.Compress {
+		if err := gzipFile(backup); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, "xxx compress log file", backup, err)
+		}
+	}
+	if f.MaxBackups == 0 && f.MaxAge == 0 {
+		return
+	}
+	ext := filepath.Ext(f.Path)
+	paths, _ := filepath.Glob(strings.TrimSuffix(f.Path, ext) + "-*" + ext + "*")
+	var backups []os.FileInfo
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			backups = append(backups, info)
+		}
+	}
+	// the newest come first
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ModTime().After(backups[j].ModTime()) })
+	dir := filepath.Dir(f.Path)
+	for i, info := range backups {
+		if (f.MaxBackups > 0 && i >= f.MaxBackups) || (f.MaxAge > 0 && time.Since(info.ModTime()) > f.MaxAge) {
+			os.Remove(filepath.Join(dir, info.Name()))
+		}
+	}
+}
+
+//gzipFile is to replace path by path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+//Reopen is to close the file, it is opened again at the next write, e.g. after logrotate moved it.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+//Close is to write the file to disk and close it, a late write opens it again.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	if f.file != nil {
+		f.file.Sync()
+	}
+	f.mu.Unlock()
+	return f.Reopen()
+}
+
+//configureLogFiles is to set the outputs of the logging section, the files of an earlier configuration are closed.
+func configureLogFiles(section map[interface{}]interface{}) error {
+	var files []*RotatingFile
+	openFile := func(key string) (*RotatingFile, error) {
+		if _, ok := section[key]; !ok {
+			return nil, nil
+		}
+		f, err := NewRotatingFile(GetSection(key, section))
+		if err != nil {
+			for _, opened := range files {
+				opened.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+		return f, nil
+	}
+	access, err := openFile("file")
+	if err != nil {
+		return err
+	}
+	errorLog, err := openFile("errorfile")
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if access != nil {
+		out = access
+		if fmt.Sprint(section["stdout"]) != "false" {
+			out = io.MultiWriter(os.Stdout, access)
+		}
+	} else if errorLog != nil && fmt.Sprint(section["stdout"]) == "false" {
+		out = ioutil.Discard
+	}
+	errOut := out
+	if errorLog != nil {
+		errOut = errorLog
+	}
+	SetOutput(out, errOut)
+
+	logFilesMu.Lock()
+	previous := logFiles
+	logFiles = files
+	logFilesMu.Unlock()
+	for _, f := range previous {
+		f.Close()
+	}
+	return nil
+}
+
+//ReopenLogs is to reopen all the log files, on SIGHUP.
+func ReopenLogs() {
+	logFilesMu.Lock()
+	defer logFilesMu.Unlock()
+	for _, f := range logFiles {
+		if err := f.Reopen(); err != nil {
+			fmt.Fprintln(os.Stderr, "xxx reopen log file", f.Path, err)
+		}
+	}
+}
+
+//CloseLogs is to write the log files to disk and close them, before the proxy exits.
+func CloseLogs() {
+	logFilesMu.Lock()
+	defer logFilesMu.Unlock()
+	for _, f := range logFiles {
+		if err := f.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, "xxx close log file", f.Path, err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+//newTestFile is a log file in a temp dir, read from section with its path added.
+func newTestFile(t *testing.T, section map[interface{}]interface{}) *RotatingFile {
+	t.Helper()
+	section["path"] = filepath.Join(t.TempDir(), "log", "davinci.log")
+	f, err := NewRotatingFile(section)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		// a cleanup still running would write in the removed dir
+		f.cleanMu.Lock()
+		f.cleanMu.Unlock()
+	})
+	return f
+}
+
+//backups is the rotated files of f, sorted.
+func backups(t *testing.T, f *RotatingFile) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(f.Path), "davinci-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+//waitFor is to wait for the cleanup after a rotation, which runs in the background.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+	}
+}
+
+func write(t *testing.T, f *RotatingFile, line string) {
+	t.Helper()
+	if _, err := f.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNewRotatingFile(t *testing.T) {
+	f := newTestFile(t, map[interface{}]interface{}{"maxsize": 2, "rotate": "24h", "maxbackups": 7, "maxage": "720h", "compress": true})
+	if f.MaxSize != 2<<20 || f.Interval != 24*time.Hour || f.MaxBackups != 7 || f.MaxAge != 720*time.Hour || !f.Compress {
+		t.Errorf("read %+v", f)
+	}
+	for _, section := range []map[interface{}]interface{}{
+		{},
+		{"path": filepath.Join(t.TempDir(), "a.log"), "maxsize": "big"},
+		{"path": filepath.Join(t.TempDir(), "a.log"), "maxbackups": -1},
+		{"path": filepath.Join(t.TempDir(), "a.log"), "rotate": "daily"},
+	} {
+		if _, err := NewRotatingFile(section); err == nil {
+			t.Errorf("%v is read", section)
+		}
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	f := newTestFile(t, map[interface{}]interface{}{})
+	f.MaxSize = 10
+	write(t, f, "12345\n")
+	write(t, f, "678\n")
+	if got := backups(t, f); len(got) != 0 {
+		t.Fatalf("rotated before the file is full: %v", got)
+	}
+	write(t, f, "next\n")
+	got := backups(t, f)
+	if len(got) != 1 {
+		t.Fatalf("backups %v, want one", got)
+	}
+	if content := readFile(t, got[0]); content != "12345\n678\n" {
+		t.Errorf("backup %q", content)
+	}
+	if content := readFile(t, f.Path); content != "next\n" {
+		t.Errorf("file %q after the rotation", content)
+	}
+
+	// a line longer than the limit still goes to a fresh file
+	write(t, f, "a line longer than ten bytes\n")
+	if got := backups(t, f); len(got) != 2 {
+		t.Errorf("backups %v, want two", got)
+	}
+}
+
+func TestRotateByInterval(t *testing.T) {
+	f := newTestFile(t, map[interface{}]interface{}{"rotate": "1h"})
+	write(t, f, "first hour\n")
+	if f.nextRotate.Sub(time.Now()) > time.Hour || !f.nextRotate.Equal(f.nextRotate.Truncate(time.Hour)) {
+		t.Errorf("next rotation at %v, want the next hour", f.nextRotate)
+	}
+	f.nextRotate = time.Now().Add(-time.Second)
+	write(t, f, "second hour\n")
+	got := backups(t, f)
+	if len(got) != 1 || readFile(t, got[0]) != "first hour\n" {
+		t.Fatalf("backups %v, want the first hour", got)
+	}
+	if content := readFile(t, f.Path); content != "second hour\n" {
+		t.Errorf("file %q after the rotation", content)
+	}
+	if !f.nextRotate.After(time.Now()) {
+		t.Errorf("next rotation at %v, in the past", f.nextRotate)
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	f := newTestFile(t, map[interface{}]interface{}{"compress": true})
+	f.MaxSize = 10
+	write(t, f, "0123456789")
+	write(t, f, "next\n")
+	var got []string
+	waitFor(t, "the gzip of the backup", func() bool {
+		got = backups(t, f)
+		return len(got) == 1 && filepath.Ext(got[0]) == ".gz"
+	})
+	file, err := os.Open(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "0123456789" {
+		t.Errorf("backup %q", data)
+	}
+}
+
+func TestRotateRetention(t *testing.T) {
+	t.Run("maxbackups", func(t *testing.T) {
+		f := newTestFile(t, map[interface{}]interface{}{"maxbackups": 2})
+		f.MaxSize = 1
+		for _, line := range []string{"1", "2", "3", "4", "5"} {
+			write(t, f, line)
+		}
+		waitFor(t, "the removal of the oldest backups", func() bool { return len(backups(t, f)) == 2 })
+	})
+	t.Run("maxage", func(t *testing.T) {
+		f := newTestFile(t, map[interface{}]interface{}{"maxage": "24h"})
+		dir := filepath.Dir(f.Path)
+		old := time.Now().Add(-48 * time.Hour)
+		for _, name := range []string{"davinci-20200101T000000.log", "davinci-20200102T000000.log.gz"} {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		recent := filepath.Join(dir, "davinci-20200103T000000.log")
+		if err := ioutil.WriteFile(recent, []byte("recent\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f.MaxSize = 1
+		write(t, f, "1")
+		write(t, f, "2")
+		waitFor(t, "the removal of the old backups", func() bool { return len(backups(t, f)) == 2 })
+		if !fileExists(recent) {
+			t.Error("a recent backup is removed")
+		}
+	})
+}
+
+func TestReopen(t *testing.T) {
+	f := newTestFile(t, map[interface{}]interface{}{})
+	write(t, f, "before\n")
+	moved := f.Path + ".1"
+	if err := os.Rename(f.Path, moved); err != nil {
+		t.Fatal(err)
+	}
+	// until the reopen the lines go to the moved file, as with logrotate
+	write(t, f, "moved\n")
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	write(t, f, "after\n")
+	if content := readFile(t, moved); content != "before\nmoved\n" {
+		t.Errorf("moved file %q", content)
+	}
+	if content := readFile(t, f.Path); content != "after\n" {
+		t.Errorf("reopened file %q", content)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Errorf("reopen twice: %v", err)
+	}
+}
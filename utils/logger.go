@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Levelled logging, in the logging section of config.yaml:
+   logging:
+     level: info          # debug, info, warn or error; can be changed at runtime
+     format: console      # console or json
+   A Logger carries fields, e.g. the remote address of a connection, and is passed along in the context of a request
+   so all the lines of one request can be correlated:
+     log := utils.LoggerFrom(ctx).With(utils.F("reqid", id))
+     log.Info("sending result to app client")
+   分级日志：支持运行时调整级别、JSON或文本输出，并通过context关联同一请求的日志。
+*/
+
+//Level is the severity of a log line.
+type Level int32
+
+//log levels.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+//ParseLevel is to read a level name.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(i), nil
+		}
+	}
+	if strings.EqualFold(name, "warning") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+}
+
+//log formats.
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+//Field is a key and value attached to the log lines.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+//F is a shorthand for Field{key, value}.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+//Logger writes log lines with its fields, it is immutable and safe for concurrent use.
+type Logger struct {
+	fields []Field
+}
+
+var (
+	logLevel  = int32(LevelInfo)
+	logFormat atomic.Value
+	logMu     sync.Mutex
+	logOut    io.Writer = os.Stdout
+	logErrOut io.Writer = os.Stdout
+	rootLog             = &Logger{}
+)
+
+func init() {
+	logFormat.Store(FormatConsole)
+}
+
+//SetLevel is to change the level of all the loggers, at runtime too.
+func SetLevel(level Level) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+//GetLevel is the current log level.
+func GetLevel() Level {
+	return Level(atomic.LoadInt32(&logLevel))
+}
+
+//SetFormat is to write the log lines as console text or json.
+func SetFormat(format string) error {
+	if format != FormatConsole && format != FormatJSON {
+		return fmt.Errorf("unknown log format %q, use console or json", format)
+	}
+	logFormat.Store(format)
+	return nil
+}
+
+//SetOutput is to write the log lines to out, and the warn and error lines to errOut as well when it differs.
+func SetOutput(out io.Writer, errOut io.Writer) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	logOut, logErrOut = out, errOut
+}
+
+//ConfigureLogging is to apply the logging section of the config, the log files of logfile.go included.
+func ConfigureLogging(section map[interface{}]interface{}) error {
+	if section == nil {
+		return nil
+	}
+	// checked before anything changes, a config reload keeps logging as it was when the section is wrong
+	level := GetLevel()
+	if _, ok := section["level"]; ok {
+		var err error
+		if level, err = ParseLevel(GetElement("level", section)); err != nil {
+			return err
+		}
+	}
+	format := logFormat.Load().(string)
+	if _, ok := section["format"]; ok {
+		format = GetElement("format", section)
+		if format != FormatConsole && format != FormatJSON {
+			return fmt.Errorf("unknown log format %q, use console or json", format)
+		}
+	}
+	if err := configureLogFiles(section); err != nil {
+		return err
+	}
+	SetLevel(level)
+	return SetFormat(format)
+}
+
+//With returns a logger with the fields added to the ones of the root logger.
+func With(fields ...Field) *Logger {
+	return rootLog.With(fields...)
+}
+
+//With returns a logger with the fields added to the ones of l.
+func (l *Logger) With(fields ...Field) *Logger {
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+	return &Logger{fields: all}
+}
+
+type loggerKey struct{}
+
+//ContextWithLogger returns a copy of ctx carrying l.
+func ContextWithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+//LoggerFrom is the logger of a request, the root logger if ctx has none.
+func LoggerFrom(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return rootLog
+}
+
+//NewRequestID is a random id to correlate the log lines of one request.
+func NewRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+//Debug writes v at debug level, the arguments are joined as by fmt.Sprintln.
+func (l *Logger) Debug(v ...interface{}) {
+	l.output(LevelDebug, 2, v)
+}
+
+//Info writes v at info level.
+func (l *Logger) Info(v ...interface{}) {
+	l.output(LevelInfo, 2, v)
+}
+
+//Warn writes v at warn level.
+func (l *Logger) Warn(v ...interface{}) {
+	l.output(LevelWarn, 2, v)
+}
+
+//Error writes v at error level with the caller.
+func (l *Logger) Error(v ...interface{}) {
+	l.output(LevelError, 2, v)
+}
+
+//Enabled tells whether lines at level are written, to skip building expensive messages.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= GetLevel()
+}
+
+func (l *Logger) output(level Level, skip int, v []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := time.Now()
+	msg := strings.TrimSuffix(fmt.Sprintln(Redact(v)...), "\n")
+	fields := l.fields
+	if level >= LevelError {
+		if _, file, line, ok := runtime.Caller(skip); ok {
+			fields = append(fields[:len(fields):len(fields)], F("caller", filepath.Base(filepath.Dir(file))+"/"+filepath.Base(file)+":"+fmt.Sprint(line)))
+		}
+	}
+
+	var line []byte
+	if logFormat.Load().(string) == FormatJSON {
+		entry := make(map[string]interface{}, len(fields)+3)
+		for _, f := range fields {
+			entry[f.Key] = redactValue(f.Key, f.Value)
+		}
+		entry["time"] = now.Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["msg"] = msg
+		data, err := json.Marshal(entry)
+		if err != nil {
+			data, _ = json.Marshal(map[string]interface{}{"time": entry["time"], "level": entry["level"], "msg": msg})
+		}
+		line = append(data, '\n')
+	} else {
+		var b strings.Builder
+		b.WriteString(now.Format("2006-01-02 15:04:05.000"))
+		b.WriteString(" [" + strings.ToUpper(level.String()) + "] ")
+		b.WriteString(msg)
+		for _, f := range fields {
+			fmt.Fprintf(&b, " %s=%v", f.Key, redactValue(f.Key, f.Value))
+		}
+		b.WriteByte('\n')
+		line = []byte(b.String())
+	}
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	logOut.Write(line)
+	if level >= LevelWarn && logErrOut != logOut {
+		logErrOut.Write(line)
+	}
+}
+
+//redactValue is to mask the secrets in a field value, the allowed fields of the redaction are kept as they are.
+func redactValue(key string, value interface{}) interface{} {
+	if redactor.Load().(*Redactor).allowed(key) {
+		return value
+	}
+	switch value.(type) {
+	case string, []byte, fmt.Stringer, error:
+		return Redact([]interface{}{value})[0]
+	}
+	return value
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/* Prometheus metrics, served on /metrics when metricshost is set in config.yaml:
+   metricshost: localhost:10391
+   All the series are named goproxy_*, the requests are labelled by method, route and code the same way everywhere;
+   code is 0 for a success and the json-rpc error code otherwise; method is the one sent by the app client when it is
+   one of KnownMethods, and other otherwise, so the clients can't add series.
+   There is no cache in the proxy yet, a cache would add its hits and misses here.
+   监控指标：连接数、帧解析、请求量与延迟、上游调用延迟和心跳超时。
+*/
+
+const metricsNamespace = "goproxy"
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	activeConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "connections_active",
+		Help:      "TCP connections of app clients currently open.",
+	})
+	framesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "frames_received_total",
+		Help:      "Frames unpacked from the app client connections.",
+	})
+	framesDecoded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "frames_decoded_total",
+		Help:      "Frames decoded into a request.",
+	})
+	frameDecodeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "frame_decode_errors_total",
+		Help:      "Frames that aren't a valid request.",
+	})
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Requests answered, by method, route and result code.",
+	}, []string{"method", "route", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time to answer a request in the controller, middlewares included.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time of the calls to the block chain service, by endpoint and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "method"})
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_errors_total",
+		Help:      "Calls to the block chain service without a response, by endpoint and method.",
+	}, []string{"endpoint", "method"})
+	heartbeatTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "heartbeat_timeouts_total",
+		Help:      "Connections closed because the app client stopped sending.",
+	})
+	connectionsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "connections_rejected_total",
+		Help:      "Connections closed at once because the listener had maxconnections open, by listener.",
+	}, []string{"listener"})
+	auditHeadSeq = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "audit_head_seq",
+		Help:      "Seq of the last entry appended to the audit log, a file ending before it was truncated.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		activeConnections, framesReceived, framesDecoded, frameDecodeErrors,
+		requests, requestDuration, upstreamDuration, upstreamErrors, heartbeatTimeouts, connectionsRejected,
+		auditHeadSeq,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+//MetricsHandler serves the metrics in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+//ConnectionOpened is to count an app client connection, the returned func is called when it is closed.
+func ConnectionOpened() func() {
+	activeConnections.Inc()
+	return activeConnections.Dec
+}
+
+//FramesReceived is to count the frames unpacked from a connection.
+func FramesReceived(n int) {
+	framesReceived.Add(float64(n))
+}
+
+//FrameDecoded is to count a frame decoded into a request, or not when err isn't nil.
+func FrameDecoded(err error) {
+	if err != nil {
+		frameDecodeErrors.Inc()
+		return
+	}
+	framesDecoded.Inc()
+}
+
+//otherMethod is the method label of the requests whose method isn't known.
+const otherMethod = "other"
+
+var knownMethods = struct {
+	sync.RWMutex
+	methods map[string]bool
+}{methods: make(map[string]bool)}
+
+//KnownMethods is to label the requests of methods by their method, the other requests are labelled other.
+func KnownMethods(methods ...string) {
+	knownMethods.Lock()
+	defer knownMethods.Unlock()
+	for _, method := range methods {
+		knownMethods.methods[method] = true
+	}
+}
+
+//methodLabel is the method label of a request of method.
+func methodLabel(method string) string {
+	knownMethods.RLock()
+	defer knownMethods.RUnlock()
+	if knownMethods.methods[method] {
+		return method
+	}
+	return otherMethod
+}
+
+//ObserveRequest is to count a request answered with code after elapsed.
+func ObserveRequest(method string, route string, code int, elapsed time.Duration) {
+	method = methodLabel(method)
+	requests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	requestDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+//ObserveUpstream is to count a call to the block chain service, err is set when there was no response.
+func ObserveUpstream(endpoint string, method string, elapsed time.Duration, err error) {
+	method = methodLabel(method)
+	upstreamDuration.WithLabelValues(endpoint, method).Observe(elapsed.Seconds())
+	if err != nil {
+		upstreamErrors.WithLabelValues(endpoint, method).Inc()
+	}
+}
+
+//HeartbeatTimedOut is to count a connection closed for lack of heartbeat.
+func HeartbeatTimedOut() {
+	heartbeatTimeouts.Inc()
+}
+
+//ConnectionRejected is to count a connection closed because listener was full.
+func ConnectionRejected(listener string) {
+	connectionsRejected.WithLabelValues(listener).Inc()
+}
+
+//AuditAppended is to note seq is the last entry of the audit log.
+func AuditAppended(seq uint64) {
+	auditHeadSeq.Set(float64(seq))
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 )
 
 /* A custom communication protocol between server and client;
@@ -48,6 +50,39 @@ func Depack(buffer []byte) []byte {
 	return data
 }
 
+//ErrFrameLength is a frame whose length is negative or larger than the maximum, the connection can't be read further.
+var ErrFrameLength = errors.New("frame length out of range")
+
+//Unpack is to split raw stream data into complete messages;
+//the bytes of an incomplete message are returned as rest and should be prepended to the next read.
+//A frame longer than maxLength is an ErrFrameLength, the stream is out of sync and the connection should be closed.
+func Unpack(buffer []byte, maxLength int) (messages [][]byte, rest []byte, err error) {
+	length := len(buffer)
+
+	var i int
+	for i = 0; i < length; i = i + 1 {
+		if length < i+ConstHeaderLength+ConstMLength {
+			break
+		}
+		if string(buffer[i:i+ConstHeaderLength]) == ConstHeader {
+			messageLength := BytesToInt(buffer[i+ConstHeaderLength : i+ConstHeaderLength+ConstMLength])
+			if messageLength < 0 || messageLength > maxLength {
+				return messages, nil, fmt.Errorf("%w: %d bytes, at most %d", ErrFrameLength, messageLength, maxLength)
+			}
+			if length < i+ConstHeaderLength+ConstMLength+messageLength {
+				break
+			}
+			messages = append(messages, buffer[i+ConstHeaderLength+ConstMLength:i+ConstHeaderLength+ConstMLength+messageLength])
+			i += ConstHeaderLength + ConstMLength + messageLength - 1
+		}
+	}
+
+	if i == length {
+		return messages, make([]byte, 0), nil
+	}
+	return messages, append([]byte(nil), buffer[i:]...), nil
+}
+
 //IntToBytes is a utility for encode/decode
 func IntToBytes(n int) []byte {
 	x := int32(n)
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+//frame is a frame with the length n, whatever the length of message.
+func frame(n int, message string) []byte {
+	return append(append([]byte(ConstHeader), IntToBytes(n)...), message...)
+}
+
+func TestUnpack(t *testing.T) {
+	join := func(frames ...[]byte) []byte { return bytes.Join(frames, nil) }
+	tests := []struct {
+		name     string
+		buffer   []byte
+		messages []string
+		rest     []byte
+		err      error
+	}{
+		{name: "empty", buffer: nil, rest: []byte{}},
+		{name: "one frame", buffer: Enpack([]byte(`{"a":1}`)), messages: []string{`{"a":1}`}, rest: []byte{}},
+		{name: "empty message", buffer: Enpack(nil), messages: []string{""}, rest: []byte{}},
+		{name: "back to back", buffer: join(Enpack([]byte("one")), Enpack([]byte("two")), Enpack([]byte("three"))),
+			messages: []string{"one", "two", "three"}, rest: []byte{}},
+		{name: "truncated header", buffer: []byte("testHea"), rest: []byte("testHea")},
+		{name: "truncated length", buffer: []byte("testHeader\x00\x00"), rest: []byte("testHeader\x00\x00")},
+		{name: "truncated message", buffer: frame(10, "12345"), rest: frame(10, "12345")},
+		{name: "frame then truncated", buffer: join(Enpack([]byte("one")), frame(4, "tw")),
+			messages: []string{"one"}, rest: frame(4, "tw")},
+		{name: "garbage before the header", buffer: join([]byte("xx"), Enpack([]byte("one"))),
+			messages: []string{"one"}, rest: []byte{}},
+		{name: "negative length", buffer: frame(-1, "1234"), err: ErrFrameLength},
+		{name: "negative length without message", buffer: append([]byte(ConstHeader), 0x80, 0, 0, 0), err: ErrFrameLength},
+		{name: "oversized", buffer: frame(1<<31-1, "1234"), err: ErrFrameLength},
+		{name: "largest", buffer: Enpack(bytes.Repeat([]byte("x"), 64)), messages: []string{string(bytes.Repeat([]byte("x"), 64))}, rest: []byte{}},
+		{name: "one byte too long", buffer: frame(65, "x"), err: ErrFrameLength},
+		{name: "oversized after a frame", buffer: join(Enpack([]byte("one")), frame(1000, "")),
+			messages: []string{"one"}, err: ErrFrameLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, rest, err := Unpack(tt.buffer, 64)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if len(messages) != len(tt.messages) {
+				t.Fatalf("messages = %q, want %q", messages, tt.messages)
+			}
+			for i, message := range messages {
+				if string(message) != tt.messages[i] {
+					t.Errorf("messages[%d] = %q, want %q", i, message, tt.messages[i])
+				}
+			}
+			if err == nil && !bytes.Equal(rest, tt.rest) {
+				t.Errorf("rest = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestUnpackByteByByte(t *testing.T) {
+	stream := append(Enpack([]byte("one")), Enpack([]byte("two"))...)
+	var got []string
+	var rest []byte
+	for _, b := range stream {
+		var messages [][]byte
+		var err error
+		messages, rest, err = Unpack(append(rest, b), 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, message := range messages {
+			got = append(got, string(message))
+		}
+	}
+	if len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Errorf("messages = %q", got)
+	}
+}
@@ -2,59 +2,255 @@ package utils
 
 import (
 	"bytes"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v2"
 )
 
-//GetYamlConfig is to get config from yaml file.
-func GetYamlConfig(path string) map[interface{}]interface{} {
-	data, err := ioutil.ReadFile(path)
-	m := make(map[interface{}]interface{})
+//GetXMLConfig is to get the top level values of an XML file, see ReadConfigYAML for the elements.
+func GetXMLConfig(path string) map[string]string {
+	values := make(map[string]string)
+	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		LogErr(err)
+		return values
+	}
+	tree, err := xmlToTree(content)
+	if err != nil {
+		LogErr(path, err)
+		return values
 	}
-	err = yaml.Unmarshal([]byte(data), &m)
-	return m
+	for key, value := range tree {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+	return values
 }
 
-//GetXMLConfig is to get config info from XML file.
-func GetXMLConfig(path string) map[string]string {
-	var t xml.Token
-	var err error
+/* The config file is yaml, json, toml or xml, chosen by the extension: .yaml or .yml, .json, .toml, .xml.
+   In xml the root element holds the keys, an attribute is a key as well as a child element,
+   a repeated element is a list, and so is an element whose children are all <item>;
+   the text is a string, and a number or a boolean only for a key which is one in Config, so <mode>0660</mode> stays "0660":
+     <config>
+       <host>localhost:10399</host>
+       <logging level="info"><file path="./log/davinci.log" maxsize="100"/></logging>
+       <acl><rules><item><principals>app-1</principals><methods>source-state</methods><methods>source-history</methods></item></rules></acl>
+     </config>
+   配置文件支持yaml、json、toml和xml，按扩展名选择，都读入同一个Config。
+*/
 
-	Keylst := make([]string, 6)
-	Valuelst := make([]string, 6)
+//ConfigFormat is the format of the config file at path, by its extension.
+func ConfigFormat(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		return "yaml", nil
+	case ".json":
+		return "json", nil
+	case ".toml":
+		return "toml", nil
+	case ".xml":
+		return "xml", nil
+	default:
+		return "", fmt.Errorf("%s: unknown config format %q, use .yaml, .json, .toml or .xml", path, ext)
+	}
+}
 
-	map1 := make(map[string]string)
-	content, err := ioutil.ReadFile(path)
+//ReadConfigYAML is to read the config file at path as yaml, whatever its format.
+func ReadConfigYAML(path string) (data []byte, format string, err error) {
+	if format, err = ConfigFormat(path); err != nil {
+		return nil, "", err
+	}
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return nil, format, err
+	}
+	var tree interface{}
+	switch format {
+	case "yaml":
+		return data, format, nil
+	case "json":
+		err = json.Unmarshal(data, &tree)
+	case "toml":
+		var table map[string]interface{}
+		_, err = toml.Decode(string(data), &table)
+		tree = table
+	case "xml":
+		var root map[string]interface{}
+		root, err = xmlToTree(data)
+		tree = xmlTyped(root, reflect.TypeOf(Config{}))
+	}
 	if err != nil {
-		LogErr(err)
+		return nil, format, fmt.Errorf("%s: %v", path, err)
+	}
+	if data, err = yaml.Marshal(tree); err != nil {
+		return nil, format, fmt.Errorf("%s: %v", path, err)
 	}
-	decoder := xml.NewDecoder(bytes.NewBuffer(content))
+	return data, format, nil
+}
 
-	i := 0
-	j := 0
-	for t, err = decoder.Token(); err == nil; t, err = decoder.Token() {
+//xmlToTree is to read an xml document as the map of the children of its root element.
+func xmlToTree(content []byte) (map[string]interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no root element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			tree, err := xmlElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+			if m, ok := tree.(map[string]interface{}); ok {
+				return m, nil
+			}
+			return map[string]interface{}{}, nil
+		}
+	}
+}
 
-		switch token := t.(type) {
+//xmlElement is to read the element started by start: a map of its attributes and children, a list, or its text.
+func xmlElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	children := make(map[string]interface{})
+	repeated := make(map[string]bool)
+	add := func(name string, value interface{}) {
+		existing, ok := children[name]
+		switch {
+		case !ok:
+			children[name] = value
+		case repeated[name]:
+			children[name] = append(existing.([]interface{}), value)
+		default:
+			children[name] = []interface{}{existing, value}
+			repeated[name] = true
+		}
+	}
+	for _, attr := range start.Attr {
+		add(attr.Name.Local, attr.Value)
+	}
+	var text bytes.Buffer
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("<%s>: %v", start.Name.Local, err)
+		}
+		switch t := token.(type) {
 		case xml.StartElement:
-			name := token.Name.Local
-			Keylst[i] = string(name)
-			i = i + 1
+			child, err := xmlElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			add(t.Name.Local, child)
 		case xml.CharData:
-			content1 := string([]byte(token))
-			Valuelst[j] = content1
-			j = j + 1
+			text.Write(t)
+		case xml.EndElement:
+			if len(children) == 0 {
+				if s := strings.TrimSpace(text.String()); s != "" {
+					return s, nil
+				}
+				return nil, nil
+			}
+			if items, ok := children["item"]; ok && len(children) == 1 {
+				if list, ok := items.([]interface{}); ok && repeated["item"] {
+					return list, nil
+				}
+				return []interface{}{items}, nil
+			}
+			return children, nil
 		}
 	}
-	for count := 0; count < len(Keylst); count++ {
-		map1[Keylst[count]] = Valuelst[count]
+}
+
+//yamlUnmarshaler is a type reading itself from yaml, e.g. Duration, given the text as it is.
+var yamlUnmarshaler = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+//xmlTyped is the xml tree value with the text of the numeric and bool keys of t converted, the other text stays a string;
+//a single element for a list key is a list of one.
+func xmlTyped(value interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(yamlUnmarshaler) {
+		return value
+	}
+	if _, ok := value.([]interface{}); !ok && value != nil && t.Kind() == reflect.Slice {
+		value = []interface{}{value}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			switch t.Kind() {
+			case reflect.Struct:
+				if field, ok := yamlField(t, key); ok {
+					v[key] = xmlTyped(child, field.Type)
+				}
+			case reflect.Map:
+				v[key] = xmlTyped(child, t.Elem())
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice {
+			for i, item := range v {
+				v[i] = xmlTyped(item, t.Elem())
+			}
+		}
+	case string:
+		return xmlScalar(v, t.Kind())
+	}
+	return value
+}
+
+//yamlField is the field of the struct type t read from key.
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		if name == key {
+			return field, true
+		}
 	}
+	return reflect.StructField{}, false
+}
 
-	return map1
+//xmlScalar is the text s of an element or attribute as a value of kind; it stays a string when it isn't one,
+//for the config to report the key.
+func xmlScalar(s string, kind reflect.Kind) interface{} {
+	switch kind {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return n
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
 }
 
 //GetElement is a helper function.
@@ -64,6 +260,16 @@ func GetElement(key string, themap map[interface{}]interface{}) string {
 		return fmt.Sprint(value)
 	}
 
-	Log("can't find the config file")
+	Log("can't find", key, "in the config")
 	return ""
 }
+
+//GetSection is to get a nested section of the config, nil if there is none.
+func GetSection(key string, themap map[interface{}]interface{}) map[interface{}]interface{} {
+	if value, ok := themap[key]; ok {
+		if section, ok := value.(map[interface{}]interface{}); ok {
+			return section
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//sameConfig is one config in each format, with values a format could read as another type.
+var sameConfig = map[string]string{
+	"config.yaml": `
+host: localhost:10399
+beatinginterval: 15
+draintimeout: 45s
+maxframesize: 65536
+upstream: https://chain.example.com/rpc
+listeners:
+  - name: sidecar
+    network: unix
+    address: /tmp/proxy.sock
+    mode: "0660"
+    auth: {required: false, timeout: 3}
+auth:
+  required: true
+  clients:
+    app-1: {apikey: "0123", groups: [farm]}
+    app-2: {apikey: "yes"}
+acl:
+  rules:
+    - principals: "007"
+      methods: [source-state, source-history]
+limits:
+  global: {rate: 2.5, burst: 10}
+logging:
+  level: info
+  stdout: false
+  file: {path: ./log/proxy.log, maxsize: 100, rotate: 24h}
+tracing:
+  exporter: file
+  file: ./log/traces.json
+  ratio: 0.25
+`,
+	"config.json": `{
+  "host": "localhost:10399",
+  "beatinginterval": 15,
+  "draintimeout": "45s",
+  "maxframesize": 65536,
+  "upstream": "https://chain.example.com/rpc",
+  "listeners": [{"name": "sidecar", "network": "unix", "address": "/tmp/proxy.sock", "mode": "0660",
+                 "auth": {"required": false, "timeout": 3}}],
+  "auth": {"required": true, "clients": {"app-1": {"apikey": "0123", "groups": ["farm"]}, "app-2": {"apikey": "yes"}}},
+  "acl": {"rules": [{"principals": "007", "methods": ["source-state", "source-history"]}]},
+  "limits": {"global": {"rate": 2.5, "burst": 10}},
+  "logging": {"level": "info", "stdout": false, "file": {"path": "./log/proxy.log", "maxsize": 100, "rotate": "24h"}},
+  "tracing": {"exporter": "file", "file": "./log/traces.json", "ratio": 0.25}
+}`,
+	"config.toml": `
+host = "localhost:10399"
+beatinginterval = 15
+draintimeout = "45s"
+maxframesize = 65536
+upstream = "https://chain.example.com/rpc"
+
+[[listeners]]
+name = "sidecar"
+network = "unix"
+address = "/tmp/proxy.sock"
+mode = "0660"
+auth = {required = false, timeout = 3}
+
+[auth]
+required = true
+[auth.clients.app-1]
+apikey = "0123"
+groups = ["farm"]
+[auth.clients.app-2]
+apikey = "yes"
+
+[[acl.rules]]
+principals = "007"
+methods = ["source-state", "source-history"]
+
+[limits.global]
+rate = 2.5
+burst = 10
+
+[logging]
+level = "info"
+stdout = false
+file = {path = "./log/proxy.log", maxsize = 100, rotate = "24h"}
+
+[tracing]
+exporter = "file"
+file = "./log/traces.json"
+ratio = 0.25
+`,
+	"config.xml": `<config>
+  <host>localhost:10399</host>
+  <beatinginterval>15</beatinginterval>
+  <draintimeout>45s</draintimeout>
+  <maxframesize>65536</maxframesize>
+  <upstream>https://chain.example.com/rpc</upstream>
+  <listeners>
+    <item name="sidecar" network="unix" address="/tmp/proxy.sock">
+      <mode>0660</mode>
+      <auth required="false" timeout="3"/>
+    </item>
+  </listeners>
+  <auth required="true">
+    <clients>
+      <app-1 apikey="0123"><groups>farm</groups></app-1>
+      <app-2><apikey>yes</apikey></app-2>
+    </clients>
+  </auth>
+  <acl><rules><item><principals>007</principals><methods>source-state</methods><methods>source-history</methods></item></rules></acl>
+  <limits><global rate="2.5" burst="10"/></limits>
+  <logging level="info" stdout="false"><file path="./log/proxy.log" maxsize="100" rotate="24h"/></logging>
+  <tracing exporter="file" file="./log/traces.json" ratio="0.25"/>
+</config>`,
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	configs := make(map[string]*Config)
+	for name, content := range sameConfig {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		configs[name] = config
+	}
+
+	want := configs["config.yaml"]
+	if l := want.Listeners[0]; l.Mode != "0660" {
+		t.Errorf("yaml: mode = %q, want 0660", l.Mode)
+	}
+	if c := want.Auth.Clients["app-1"]; c.APIKey != "0123" {
+		t.Errorf("yaml: apikey = %q, want 0123", c.APIKey)
+	}
+	if c := want.Auth.Clients["app-2"]; c.APIKey != "yes" {
+		t.Errorf("yaml: apikey = %q, want yes", c.APIKey)
+	}
+	if want.DrainTimeout != Duration(45*time.Second) || want.BeatingInterval != 15 || want.MaxFrameSize != 65536 {
+		t.Errorf("yaml: draintimeout %v, beatinginterval %d, maxframesize %d", want.DrainTimeout, want.BeatingInterval, want.MaxFrameSize)
+	}
+	for name, config := range configs {
+		if !reflect.DeepEqual(config, want) {
+			t.Errorf("%s differs from config.yaml:\n%s\nwant\n%s", name, config.Map(), want.Map())
+		}
+	}
+}
+
+func TestXMLWrongType(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.xml")
+	if err := ioutil.WriteFile(path, []byte(`<config><beatinginterval>0x10</beatinginterval></config>`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("beatinginterval 0x10 is read as a number")
+	}
+}
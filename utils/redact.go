@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+/* Redaction of the secrets in the logs, on by default; the redaction section of config.yaml adds to the defaults:
+   redaction:
+     headers: [X-Api-Key, Authorization]          # header names
+     fields: [apiKey, signature.value]            # json field paths, a path matches the end of the full path
+     allowfields: [tx_id]                         # json fields never masked as high-entropy tokens
+     entropy: true                                # mask long random looking tokens
+     unsafe-disable: false                        # log the secrets as they are, only to debug on a test system
+   日志脱敏：默认开启，屏蔽配置的header、JSON字段以及疑似密钥的高熵字符串。
+*/
+
+var defaultRedactHeaders = []string{"X-Api-Key", "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+var defaultRedactFields = []string{"apiKey", "apikey", "secret", "password", "token", "signature", "signature.value", "dek"}
+
+var defaultAllowFields = []string{"tx_id", "prevTxId", "key", "digest", "keyId", "traceId", "nonce", "auditHead"}
+
+//highEntropyPattern is the tokens checked for entropy, long runs of hex, base64 or url-safe base64.
+var highEntropyPattern = regexp.MustCompile(`[A-Za-z0-9+/_-]{32,}={0,2}`)
+
+//minTokenEntropy is the bits per character above which a token is taken for a secret; random hex has about 3.8.
+const minTokenEntropy = 3.2
+
+//Redactor masks the secrets in the log messages.
+type Redactor struct {
+	Headers     []string
+	Fields      []string
+	AllowFields []string
+	Entropy     bool
+	// Disabled logs everything as it is, it is set by unsafe-disable only.
+	Disabled bool
+
+	textPattern *regexp.Regexp
+	fieldPaths  [][]string
+}
+
+var redactor atomic.Value
+
+func init() {
+	r, _ := NewRedactor(nil)
+	redactor.Store(r)
+}
+
+//SetRedactor is to use r for all the log messages.
+func SetRedactor(r *Redactor) {
+	redactor.Store(r)
+}
+
+//NewRedactor is to read the redaction section of the config, nil section gives the defaults.
+func NewRedactor(section map[interface{}]interface{}) (*Redactor, error) {
+	r := &Redactor{
+		Headers:     append([]string{}, defaultRedactHeaders...),
+		Fields:      append([]string{}, defaultRedactFields...),
+		AllowFields: append([]string{}, defaultAllowFields...),
+		Entropy:     true,
+	}
+	if section != nil {
+		list := func(key string) []string {
+			var values []string
+			if items, ok := section[key].([]interface{}); ok {
+				for _, item := range items {
+					values = append(values, fmt.Sprint(item))
+				}
+			}
+			return values
+		}
+		r.Headers = append(r.Headers, list("headers")...)
+		r.Fields = append(r.Fields, list("fields")...)
+		r.AllowFields = append(r.AllowFields, list("allowfields")...)
+		if v, ok := section["entropy"]; ok {
+			r.Entropy = fmt.Sprint(v) == "true"
+		}
+		r.Disabled = fmt.Sprint(section["unsafe-disable"]) == "true"
+	}
+
+	// outside json, "name: value", "name=value" and "name:[value]" are masked by the last step of the field paths
+	names := make([]string, 0, len(r.Headers)+len(r.Fields))
+	for _, h := range r.Headers {
+		names = append(names, regexp.QuoteMeta(h))
+	}
+	for _, f := range r.Fields {
+		steps := strings.Split(f, ".")
+		r.fieldPaths = append(r.fieldPaths, steps)
+		names = append(names, regexp.QuoteMeta(steps[len(steps)-1]))
+	}
+	var err error
+	r.textPattern, err = regexp.Compile(`(?i)("?\b(?:` + strings.Join(names, "|") + `)\b"?\s*[:=]\s*\[?"?)([^"\s,}\]]+)`)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//mask keeps the first characters of a secret, so two log lines can still be told apart.
+func mask(secret string) string {
+	if len(secret) > 12 {
+		return secret[:4] + "****"
+	}
+	return "****"
+}
+
+//tokenEntropy is the shannon entropy of s in bits per character.
+func tokenEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	var entropy float64
+	for _, c := range counts {
+		p := float64(c) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func (r *Redactor) maskTokens(s string) string {
+	if !r.Entropy {
+		return s
+	}
+	return highEntropyPattern.ReplaceAllStringFunc(s, func(token string) string {
+		if tokenEntropy(token) < minTokenEntropy {
+			return token
+		}
+		return mask(token)
+	})
+}
+
+//RedactText is to mask the secrets in a text that isn't json.
+func (r *Redactor) RedactText(s string) string {
+	if r.Disabled {
+		return s
+	}
+	s = r.textPattern.ReplaceAllStringFunc(s, func(match string) string {
+		parts := r.textPattern.FindStringSubmatch(match)
+		return parts[1] + mask(parts[2])
+	})
+	return r.maskTokens(s)
+}
+
+//matchesField tells whether path ends with one of the field paths.
+func (r *Redactor) matchesField(path []string) bool {
+	for _, steps := range r.fieldPaths {
+		if len(steps) > len(path) {
+			continue
+		}
+		tail := path[len(path)-len(steps):]
+		matched := true
+		for i, step := range steps {
+			if step != "*" && !strings.EqualFold(step, tail[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) allowed(name string) bool {
+	for _, f := range r.AllowFields {
+		if strings.EqualFold(f, name) {
+			return true
+		}
+	}
+	return false
+}
+
+//redactNode is to mask the secrets in a decoded json value, changed tells whether anything was masked.
+func (r *Redactor) redactNode(node interface{}, path []string) (result interface{}, changed bool) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			childPath := append(path[:len(path):len(path)], k)
+			if r.matchesField(childPath) && child != nil {
+				if _, isObject := child.(map[string]interface{}); !isObject {
+					v[k] = mask(fmt.Sprint(child))
+					changed = true
+					continue
+				}
+			}
+			if c, ok := child.(string); ok && r.allowed(k) {
+				v[k] = c
+				continue
+			}
+			var childChanged bool
+			v[k], childChanged = r.redactNode(child, childPath)
+			changed = changed || childChanged
+		}
+	case []interface{}:
+		for i, child := range v {
+			var childChanged bool
+			v[i], childChanged = r.redactNode(child, path)
+			changed = changed || childChanged
+		}
+	case string:
+		// a json document in a string, e.g. the value of a record
+		if strings.HasPrefix(strings.TrimSpace(v), "{") {
+			redacted := r.RedactJSON(v)
+			return redacted, redacted != v
+		}
+		masked := r.maskTokens(v)
+		return masked, masked != v
+	}
+	return node, changed
+}
+
+//RedactJSON is to mask the secrets in a json document, other texts are redacted by RedactText.
+func (r *Redactor) RedactJSON(s string) string {
+	if r.Disabled {
+		return s
+	}
+	var doc interface{}
+	if json.Unmarshal([]byte(s), &doc) != nil {
+		return r.RedactText(s)
+	}
+	doc, changed := r.redactNode(doc, nil)
+	if !changed {
+		return s
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return r.RedactText(s)
+	}
+	return string(data)
+}
+
+//Redact is to mask the secrets in the arguments of a log call.
+func Redact(v []interface{}) []interface{} {
+	r := redactor.Load().(*Redactor)
+	if r.Disabled {
+		return v
+	}
+	redacted := make([]interface{}, len(v))
+	for i, arg := range v {
+		var text string
+		switch a := arg.(type) {
+		case string:
+			text = a
+		case []byte:
+			text = string(a)
+		case nil:
+			redacted[i] = arg
+			continue
+		default:
+			text = fmt.Sprint(a)
+		}
+		if trimmed := strings.TrimSpace(text); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			redacted[i] = r.RedactJSON(text)
+		} else {
+			redacted[i] = r.RedactText(text)
+		}
+	}
+	return redacted
+}
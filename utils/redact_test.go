@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+//digest is a high-entropy token, the sha256 of "test".
+const digest = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func newTestRedactor(t *testing.T, config string) *Redactor {
+	t.Helper()
+	var section map[interface{}]interface{}
+	if config != "" {
+		if err := yaml.Unmarshal([]byte(config), &section); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewRedactor(section)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRedactText(t *testing.T) {
+	r := newTestRedactor(t, "")
+	custom := newTestRedactor(t, "{headers: [X-Custom-Token], fields: [owner], entropy: false}")
+	tests := []struct {
+		name     string
+		redactor *Redactor
+		text     string
+		want     string
+	}{
+		{name: "header", redactor: r, text: "X-Api-Key: k1-secret", want: "X-Api-Key: ****"},
+		{name: "header any case", redactor: r, text: "authorization=tok3n", want: "authorization=****"},
+		{name: "long value keeps its start", redactor: r, text: "apiKey=k1-secret-of-app-1 sent", want: "apiKey=k1-s**** sent"},
+		{name: "value in brackets", redactor: r, text: "password:[hunter2]", want: "password:[****]"},
+		{name: "quoted field", redactor: r, text: `params {"secret": "s2-secret"}`, want: `params {"secret": "****"}`},
+		{name: "name inside a word", redactor: r, text: "tokens=3 passwords=2", want: "tokens=3 passwords=2"},
+		{name: "plain message", redactor: r, text: "xxx doCall() response is: ok", want: "xxx doCall() response is: ok"},
+		{name: "high entropy token", redactor: r, text: "dek " + digest, want: "dek 9f86****"},
+		{name: "low entropy token", redactor: r, text: "id 0000000000000000000000000000000000000001",
+			want: "id 0000000000000000000000000000000000000001"},
+		{name: "configured header", redactor: custom, text: "X-Custom-Token: abc", want: "X-Custom-Token: ****"},
+		{name: "configured field", redactor: custom, text: "owner=lab-1", want: "owner=****"},
+		{name: "defaults kept", redactor: custom, text: "Cookie: sid=1", want: "Cookie: ****"},
+		{name: "entropy off", redactor: custom, text: "dek " + digest, want: "dek " + digest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.redactor.RedactText(tt.text); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	r := newTestRedactor(t, "{fields: [record.owner], allowfields: [batch]}")
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{name: "fields", doc: `{"apiKey":"k1","clientId":"app-1"}`, want: `{"apiKey":"****","clientId":"app-1"}`},
+		{name: "field path", doc: `{"params":{"signature":{"keyId":"k1","value":"abc"}}}`,
+			want: `{"params":{"signature":{"keyId":"k1","value":"****"}}}`},
+		{name: "configured path", doc: `{"record":{"owner":"lab-1"},"owner":"farm"}`, want: `{"owner":"farm","record":{"owner":"****"}}`},
+		{name: "in arrays", doc: `[{"token":"t1"},{"token":"t2"}]`, want: `[{"token":"****"},{"token":"****"}]`},
+		{name: "allowed fields", doc: `{"tx_id":"` + digest + `","batch":"` + digest + `","value":"` + digest + `"}`,
+			want: `{"batch":"` + digest + `","tx_id":"` + digest + `","value":"9f86****"}`},
+		{name: "json in a string", doc: `{"value":"{\"secret\":\"s3\",\"name\":\"rice\"}"}`,
+			want: `{"value":"{\"name\":\"rice\",\"secret\":\"****\"}"}`},
+		{name: "unchanged as it is", doc: `{ "name": "rice" }`, want: `{ "name": "rice" }`},
+		{name: "not json", doc: `{apiKey=k1`, want: `{apiKey=****`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.RedactJSON(tt.doc); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactDisabled(t *testing.T) {
+	r := newTestRedactor(t, "{unsafe-disable: true}")
+	for _, s := range []string{"X-Api-Key: k1-secret", `{"apiKey":"k1"}`, "dek " + digest} {
+		if got := r.RedactText(s); got != s {
+			t.Errorf("text %q redacted to %q", s, got)
+		}
+		if got := r.RedactJSON(s); got != s {
+			t.Errorf("json %q redacted to %q", s, got)
+		}
+	}
+	if r := newTestRedactor(t, "{unsafe-disable: false}"); r.Disabled {
+		t.Error("disabled by unsafe-disable: false")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	previous := redactor.Load().(*Redactor)
+	defer SetRedactor(previous)
+
+	SetRedactor(newTestRedactor(t, ""))
+	got := Redact([]interface{}{"apiKey=k1", []byte(`{"secret":"s"}`), nil, 42, map[string]string{"token": "t"}})
+	want := []interface{}{"apiKey=****", `{"secret":"****"}`, nil, "42", "map[token:****]"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("argument %d: got %#v, want %#v", i, got[i], want[i])
+		}
+	}
+
+	SetRedactor(newTestRedactor(t, "{unsafe-disable: true}"))
+	if got := Redact([]interface{}{"apiKey=k1"}); got[0] != "apiKey=k1" {
+		t.Errorf("got %v with redaction disabled", got[0])
+	}
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+/* TLS between app clients and the proxy, configured in the tls section of config.yaml:
+   tls:
+     certfile: ./conf/server.crt
+     keyfile: ./conf/server.key
+     clientcafile: ./conf/ca.crt     # verify client certificates (mTLS)
+     clientauth: require             # none, request, verify or require, require by default with clientcafile
+*/
+
+//TLSFiles is the certificates of one side of a TLS connection.
+type TLSFiles struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	ClientAuth string
+	ServerName string
+}
+
+//ServerTLSConfig is the tls.Config of the listener; with a CA file the client certificates are verified.
+func ServerTLSConfig(files TLSFiles) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	clientAuth := files.ClientAuth
+	if clientAuth == "" && files.CAFile != "" {
+		clientAuth = "require"
+	}
+	switch clientAuth {
+	case "", "none":
+		config.ClientAuth = tls.NoClientCert
+	case "request":
+		config.ClientAuth = tls.RequestClientCert
+	case "verify":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown clientauth %q, use none, request, verify or require", clientAuth)
+	}
+	if config.ClientAuth == tls.VerifyClientCertIfGiven || config.ClientAuth == tls.RequireAndVerifyClientCert {
+		if files.CAFile == "" {
+			return nil, fmt.Errorf("clientauth %s needs clientcafile", clientAuth)
+		}
+		if config.ClientCAs, err = loadCertPool(files.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+//ClientTLSConfig is the tls.Config of app client; the CA file verifies the proxy, the key pair is sent for mTLS.
+func ClientTLSConfig(files TLSFiles) (*tls.Config, error) {
+	config := &tls.Config{ServerName: files.ServerName, MinVersion: tls.VersionTLS12}
+	if files.CAFile != "" {
+		pool, err := loadCertPool(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if files.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+//Dial is to connect to the proxy, over TLS when config isn't nil.
+func Dial(server string, config *tls.Config) (net.Conn, error) {
+	if config == nil {
+		return net.Dial("tcp", server)
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	return tls.Dial("tcp", server, config)
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/* Tracing, in the tracing section of config.yaml:
+   tracing:
+     exporter: otlp                   # otlp to a collector over http, or file
+     endpoint: localhost:4318         # otlp collector
+     insecure: true                   # plain http to the collector
+     file: ./log/traces.json          # spans written as json by the file exporter, for tests
+     service: goproxy4blockchain      # service.name of the spans
+     ratio: 1.0                       # share of the new traces sampled, a traced app client decides for its own
+   An app client continues its trace by sending the W3C traceparent in the meta of the message:
+     {"meta": {"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "content": {...}}
+   The spans are TaskDeliver, frame.decode, Excute and jsonrpc.doCall; the traceparent is sent on to the block chain service.
+   Without the section nothing is recorded, the traceparent of the clients is still passed on.
+   链路追踪：从客户端帧、路由、控制器到上游HTTP调用的span，支持OTLP导出或写入文件。
+*/
+
+//TracerName is the instrumentation name of the spans of the proxy.
+const TracerName = "goproxy4blockchain"
+
+var shutdownTracing = func(ctx context.Context) error { return nil }
+
+func init() {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+//Tracer is the tracer of the proxy, it doesn't record until ConfigureTracing is called with a section.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+//ConfigureTracing is to apply the tracing section of the config, nil section records nothing.
+func ConfigureTracing(section map[interface{}]interface{}) error {
+	if section == nil {
+		return nil
+	}
+	var exporter sdktrace.SpanExporter
+	var err error
+	var options []sdktrace.TracerProviderOption
+	switch name := GetElement("exporter", section); name {
+	case "otlp", "":
+		opts := []otlptracehttp.Option{}
+		if _, ok := section["endpoint"]; ok {
+			opts = append(opts, otlptracehttp.WithEndpoint(GetElement("endpoint", section)))
+		}
+		if fmt.Sprint(section["insecure"]) == "true" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if exporter, err = otlptracehttp.New(context.Background(), opts...); err != nil {
+			return err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	case "file":
+		path := GetElement("file", section)
+		if path == "" {
+			return fmt.Errorf("tracing: the file exporter needs file")
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(file)); err != nil {
+			file.Close()
+			return err
+		}
+		// written at once, so a test can read the spans as soon as the request is answered
+		options = append(options, sdktrace.WithSyncer(exporter))
+	default:
+		return fmt.Errorf("tracing: unknown exporter %q, use otlp or file", name)
+	}
+
+	ratio := 1.0
+	if _, ok := section["ratio"]; ok {
+		if ratio, err = strconv.ParseFloat(GetElement("ratio", section), 64); err != nil || ratio < 0 || ratio > 1 {
+			return fmt.Errorf("tracing: ratio is a number from 0 to 1")
+		}
+	}
+	service := TracerName
+	if _, ok := section["service"]; ok {
+		service = GetElement("service", section)
+	}
+	provider := sdktrace.NewTracerProvider(append(options,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+	)...)
+	otel.SetTracerProvider(provider)
+	shutdownTracing = provider.Shutdown
+	return nil
+}
+
+//ShutdownTracing is to export the spans still buffered, before the proxy exits.
+func ShutdownTracing(ctx context.Context) error {
+	return shutdownTracing(ctx)
+}
+
+//ContextWithTraceparent returns a copy of ctx continuing the trace of a W3C traceparent and tracestate,
+//ctx as it is when traceparent isn't valid.
+func ContextWithTraceparent(ctx context.Context, traceparent string, tracestate string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{"traceparent": traceparent}
+	if tracestate != "" {
+		carrier["tracestate"] = tracestate
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+type receivedAtKey struct{}
+
+//ContextWithReceivedAt returns a copy of ctx carrying when the frame of a request was read from the connection.
+func ContextWithReceivedAt(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, receivedAtKey{}, t)
+}
+
+//ReceivedAt is when the frame of the request was read, now if ctx doesn't tell.
+func ReceivedAt(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(receivedAtKey{}).(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}